@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -43,7 +43,7 @@ func listConfig(path string) error {
 		fmt.Printf("Properties:\n")
 
 		for key, val := range r.Properties {
-			fmt.Printf("  %v = %v\n", key, val)
+			fmt.Printf("  %v: %v\n", key, val)
 		}
 	}
 
@@ -61,33 +61,37 @@ func listConfig(path string) error {
 	fmt.Printf("Description: %v\n", cfg.Description)
 	fmt.Printf("Condition: %v\n", cfg.Condition)
 	fmt.Printf("Config Items:\n")
+	printConfigItems(cfg.Items)
 
-	for _, item := range cfg.Items {
-		fmt.Printf(" - Name: %v\n", item.Name)
-		fmt.Printf("   Resource: %v\n", item.Resource)
-		fmt.Printf("   Options: \n")
-
-		for key, val := range item.Options {
-			fmt.Printf("      %v = %v\n", key, val)
-		}
-
-		fmt.Println()
+	if len(cfg.Handlers) > 0 {
+		fmt.Printf("Handlers:\n")
+		printConfigItems(cfg.Handlers)
 	}
 
 	return nil
 
 }
 
-func runConfig(path string, props string, test bool, config string) (int, ConfigInfo) {
+func runConfig(path string, opts RunOptions) (int, ConfigInfo) {
 
 	absPath, _ := filepath.Abs(path)
 
+	ctx, cancel := withTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	stopWatching := watchSignals()
+	defer stopWatching()
+
+	config := opts.Config
+
 	if config == "" {
 		config = absPath + "/config.yaml"
 	}
 
+	scope := newVarScope()
+
 	//Add runtimes to path
-	err := loadRuntimes(absPath)
+	err := loadRuntimes(absPath, scope)
 
 	if err != nil {
 		fmt.Println("Failed to load runtimes!")
@@ -95,7 +99,7 @@ func runConfig(path string, props string, test bool, config string) (int, Config
 	}
 
 	//Get properties and apply it to environment
-	err = loadProperties(props)
+	err = loadProperties(opts.Properties, scope)
 
 	if err != nil {
 		fmt.Println("Failed to load properties!")
@@ -103,7 +107,12 @@ func runConfig(path string, props string, test bool, config string) (int, Config
 	}
 
 	//Load gatherers
-	err = loadGatherers(absPath)
+	err = loadGatherers(ctx, absPath, scope, opts.Naming)
+
+	if err == errInterrupted {
+		fmt.Println("Interrupted while running gatherers!")
+		return CFGInterrupted, ConfigInfo{}
+	}
 
 	if err != nil {
 		fmt.Println("Failed to load gatherers!")
@@ -126,169 +135,251 @@ func runConfig(path string, props string, test bool, config string) (int, Config
 		return CFGError, ConfigInfo{}
 	}
 
-	//Process config
+	//Check config applies to this system
+	if cfg.Condition != "" {
+		ok, err := evalCondition(cfg.Condition, scope.Get)
 
-	for i, item := range cfg.Items {
-		state := processConfig(item, test, res)
-		cfg.Items[i].State = state
+		if err != nil {
+			fmt.Printf("Failed to evaluate config condition!\nError: %v\n", err)
+			return CFGError, cfg
+		}
 
-		if !test {
-			if state == CFGRebootRequired {
-				fmt.Println("Requires reboot")
-				return CFGRebootRequired, cfg
-			}
+		if !ok {
+			fmt.Printf("Config condition %v is false, config is not applicable\n", cfg.Condition)
 
-			if state == CFGError {
-				fmt.Println("Error state!")
-				return CFGError, cfg
+			for i := range cfg.Items {
+				cfg.Items[i].State = CFGSkipOnDep
+				cfg.Items[i].Reason = fmt.Sprintf("config condition %q is false", cfg.Condition)
 			}
-		}
 
+			return CFGNotApplicable, cfg
+		}
 	}
 
-	return CFGConfigured, cfg
-}
-
-func processConfig(item ConfigItem, test bool, resources []ResourceInfo) int {
+	//Replace module items with the items of their modules
+	err = expandModules(ctx, &cfg, scope, opts.Naming, []string{absPath})
 
-	resource, err := findResource(item.Resource, resources)
+	if err == errInterrupted {
+		fmt.Println("Interrupted while loading modules!")
+		return CFGInterrupted, cfg
+	}
 
 	if err != nil {
-		fmt.Println("Can't find resource!")
-		return CFGError
+		fmt.Printf("Failed to load modules!\nError: %v\n", err)
+		return CFGError, cfg
 	}
 
-	if !test && item.Condition != "" {
-		if item.Condition[0] == '!' {
-			if os.Getenv(item.Condition[1:]) != "" {
-				return CFGSkipOnDep
-			}
-		} else {
-			if os.Getenv(item.Condition) == "" {
-				return CFGSkipOnDep
-			}
-		}
-	}
+	collisions := append(nameCollisions("config item", cfg.Items), nameCollisions("handler", cfg.Handlers)...)
 
-	state := runTest(item, resource)
+	for _, c := range collisions {
+		fmt.Printf("ERROR: %v\n", c)
+	}
 
-	if test || state == CFGConfigured || state == CFGError || state == CFGRebootRequired || state == CFGNotRun {
-		return state
+	if len(collisions) > 0 {
+		return CFGError, cfg
 	}
 
-	applyState := runApply(item, resource)
+	//Create an item for each value of each loop
+	if !expandLoops(&cfg, scope) {
+		fmt.Println("Failed to expand loops!")
+		return CFGError, cfg
+	}
 
-	if applyState == CFGNotRun || applyState == CFGRebootRequired || applyState == CFGError || applyState == CFGNotConfigured {
-		return applyState
+	//Check items against their resources before anything is run
+	if !preflightConfig(&cfg, res, opts.Strict, opts.Naming) {
+		fmt.Println("Config failed validation!")
+		return CFGError, cfg
 	}
 
-	state = runTest(item, resource)
+	//Items that use what another item registered wait for it
+	registerDeps(cfg.Items, scope)
+	registerDeps(cfg.Handlers, scope)
 
-	if state == CFGNotConfigured {
-		return CFGError
+	//Order config items by prerequisites
+	order, err := orderConfigItems(cfg.Items)
+
+	if err != nil {
+		fmt.Printf("Failed to order config items!\nError: %v\n", err)
+		return CFGError, cfg
 	}
 
-	return state
-}
+	if _, err := orderConfigItems(cfg.Handlers); err != nil {
+		fmt.Printf("Failed to order handlers!\nError: %v\n", err)
+		return CFGError, cfg
+	}
 
-func findResource(name string, resources []ResourceInfo) (ResourceInfo, error) {
-	for _, r := range resources {
-		if r.Name == name {
-			return r, nil
+	//Registered items are notrun until they finish, whether they ran, were skipped or never started
+	register := func(item *ConfigItem) {
+		if item.Register != "" {
+			_, itemScope := itemContext(item, nil, scope)
+			registerItem(itemScope, item)
 		}
 	}
 
-	return ResourceInfo{}, errors.New("can't find resource")
-}
+	for i := range cfg.Items {
+		register(&cfg.Items[i])
+	}
 
-func runTest(config ConfigItem, resource ResourceInfo) int {
-	for key, val := range config.Options {
-		os.Setenv(key, val)
+	for i := range cfg.Handlers {
+		register(&cfg.Handlers[i])
 	}
 
-	currentDir, _ := os.Getwd()
-	os.Chdir(resource.Path)
+	//Process config
+	result := scheduleItems(&cfg, order, opts.Parallel, opts.Test, func(item *ConfigItem) int {
+		return processConfig(ctx, item, opts, res, scope, false)
+	}, register)
+
+	//Run handlers notified by items that changed
+	if !opts.Test && result != CFGInterrupted && result != CFGRebootRequired {
+		handled := runHandlers(&cfg, opts.Parallel, func(item *ConfigItem) int {
+			return processConfig(ctx, item, opts, res, scope, true)
+		}, register)
+
+		if handled != CFGNotRun && handled != CFGConfigured {
+			result = handled
+		}
+	}
 
-	cmd := exec.Command(resource.TestCommand, resource.TestArguments...)
+	if opts.Test && result != CFGInterrupted {
+		result = complianceState(cfg.Items)
+	}
 
-	result, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("Failed to test resource\nStd:%v\nError: %v\n", result, err)
-		return CFGError
+	if result == CFGConfigured {
+		for _, item := range cfg.allItems() {
+			if item.State == CFGChanged {
+				result = CFGChanged
+				break
+			}
+		}
 	}
 
-	ret := getStateFromString(string(result))
-	msgs := getMessagesFromStd(string(result))
+	return result, cfg
+}
 
-	for _, msg := range msgs {
-		fmt.Printf("MSG: %v\n", msg)
-	}
+func printConfigItems(items []ConfigItem) {
+	for _, item := range items {
+		fmt.Printf(" - Name: %v\n", item.Name)
+		if item.Module != "" {
+			fmt.Printf("   Module: %v\n", item.Module)
+		} else {
+			fmt.Printf("   Resource: %v\n", item.Resource)
+		}
 
-	for key := range config.Options {
-		os.Unsetenv(key)
-	}
+		fmt.Printf("   PreReq: %v\n", item.PreReq)
+
+		if len(item.Notify) > 0 {
+			fmt.Printf("   Notify: %v\n", item.Notify)
+		}
 
-	os.Chdir(currentDir)
+		fmt.Printf("   Options: \n")
 
-	return ret
+		for _, key := range sortedKeys(item.Options) {
+			fmt.Printf("      %v = %v\n", key, optionString(item.Options[key]))
+		}
+
+		fmt.Println()
+	}
 }
 
-func runApply(config ConfigItem, resource ResourceInfo) int {
-	for key, val := range config.Options {
-		os.Setenv(key, val)
+//complianceState - Returns CFGNotConfigured if any tested item isn't configured or failed, otherwise CFGConfigured
+func complianceState(items []ConfigItem) int {
+	for _, item := range items {
+		switch item.State {
+		case CFGNotConfigured, CFGRebootRequired:
+			return CFGNotConfigured
+		}
+
+		if failed(item) {
+			return CFGNotConfigured
+		}
 	}
 
-	currentDir, _ := os.Getwd()
-	os.Chdir(resource.Path)
+	return CFGConfigured
+}
+
+func processConfig(ctx context.Context, item *ConfigItem, opts RunOptions, resources []ResourceInfo, scope *VarScope, handler bool) int {
+	test := opts.Test
+	resources, scope = itemContext(item, resources, scope)
 
-	cmd := exec.Command(resource.ApplyCommand, resource.ApplyArguments...)
+	resource, err := findResource(item.Resource, resources)
 
-	result, err := cmd.CombinedOutput()
 	if err != nil {
-		fmt.Printf("Failed to test resource\nStd:%v\nError: %v\n", result, err)
+		fmt.Println("Can't find resource!")
+		item.Reason = fmt.Sprintf("can't find resource %v", item.Resource)
 		return CFGError
 	}
 
-	ret := getStateFromString(string(result))
+	lookup := itemLookup(item, scope)
 
-	for key := range config.Options {
-		os.Unsetenv(key)
-	}
+	if item.Condition != "" {
+		ok, err := evalCondition(item.Condition, lookup)
 
-	os.Chdir(currentDir)
+		if err != nil {
+			fmt.Printf("Failed to evaluate condition of %v: %v\n", item.Name, err)
+			item.Reason = fmt.Sprintf("condition %q: %v", item.Condition, err)
+			return CFGError
+		}
 
-	return ret
-}
+		if !ok {
+			item.Reason = fmt.Sprintf("condition %q is false", item.Condition)
+			return CFGSkipOnDep
+		}
+	}
 
-func getStateFromString(text string) int {
+	options, unresolved, err := expandOptions(resourceOptions(*item, resource), lookup)
 
-	vars := getVarsFromStd(text)
+	if err != nil {
+		fmt.Printf("Failed to expand options of %v: %v\n", item.Name, err)
+		item.Reason = err.Error()
+		return CFGError
+	}
 
-	for key, val := range vars {
-		os.Setenv(key, val)
-		fmt.Printf("Setting Var %v = %v\n", key, val)
+	if test {
+		for _, u := range unresolved {
+			fmt.Printf("WARNING: item %v %v\n", item.Name, u)
+		}
 	}
 
-	if strings.Index(text, "##FAIL##") != -1 {
+	expanded := *item
+	expanded.Options = options
+
+	if _, errs := validateItem(expanded, resource, opts.Strict, true); len(errs) > 0 {
+		fmt.Printf("Item %v is not valid!\n", item.Name)
+		item.Reason = strings.Join(errs, "; ")
 		return CFGError
 	}
 
-	if strings.Index(text, "##CONFIGURED##") != -1 {
-		return CFGConfigured
-	}
+	run := &itemRun{ctx: ctx, item: item, resource: resource, options: options, scope: scope, logDir: opts.LogDir,
+		naming: resolveNaming(resource.Naming, opts.Naming), handler: handler}
 
-	if strings.Index(text, "##REBOOT##") != -1 {
-		return CFGRebootRequired
-	}
+	return run.retry(test)
+}
 
-	if strings.Index(text, "##NOTCONFIGURED##") != -1 {
-		return CFGNotConfigured
+func findResource(name string, resources []ResourceInfo) (ResourceInfo, error) {
+	for _, r := range resources {
+		if r.Name == name {
+			return r, nil
+		}
 	}
 
-	return CFGError
+	return ResourceInfo{}, errors.New("can't find resource")
 }
 
+//loadConfig - Loads the config file at path along with the files it includes
 func loadConfig(path string) (ConfigInfo, error) {
+	absPath, _ := filepath.Abs(path)
+
+	config, err := readConfig(absPath, nil)
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+
+	return config, err
+}
+
+//readConfig - Reads the config file at path. including is the chain of files that included it.
+func readConfig(path string, including []string) (ConfigInfo, error) {
 	file, err := os.Open(path)
 
 	if err != nil {
@@ -324,6 +415,23 @@ func loadConfig(path string) (ConfigInfo, error) {
 
 	file.Close()
 
+	for i := range config.Items {
+		config.Items[i].Options = normalizeOptions(config.Items[i].Options)
+		config.Items[i].Loop = normalizeValue(config.Items[i].Loop)
+		config.Items[i].Source = path
+	}
+
+	for i := range config.Handlers {
+		config.Handlers[i].Options = normalizeOptions(config.Handlers[i].Options)
+		config.Handlers[i].Source = path
+	}
+
+	err = mergeIncludes(&config, path, including)
+
+	if err != nil {
+		return ConfigInfo{}, err
+	}
+
 	return config, nil
 
 }
@@ -448,7 +556,7 @@ func printGatherers(path string) error {
 	return nil
 }
 
-func loadGatherers(path string) error {
+func loadGatherers(ctx context.Context, path string, scope *VarScope, naming string) error {
 	dirs, err := ioutil.ReadDir(path + "/gathers")
 
 	if err != nil {
@@ -500,45 +608,73 @@ func loadGatherers(path string) error {
 
 		file.Close()
 
-		curentDir, _ := os.Getwd()
-		os.Chdir(path + "/gathers/" + gatherName)
+		timeout, err := parseDuration(gather.Timeout)
 
-		//Execute gatherer
-		cmd := exec.Command(gather.Command, gather.Arguments...)
+		if err == nil {
+			err = validateProtocol(gather.Protocol)
+		}
 
-		out, err := cmd.CombinedOutput()
+		if err == nil {
+			err = validateNaming(gather.Naming)
+		}
+
+		if err == nil && gather.Protocol == ProtocolExitCode {
+			err = fmt.Errorf("protocol %v is only for resources", ProtocolExitCode)
+		}
 
 		if err != nil {
-			fmt.Printf("Error running gather!\nError: %v\n", string(out))
+			fmt.Printf("Failed to load gather %v: %v\n", gatherName, err)
 			return err
 		}
 
-		//Capture variables form output
-		vars := getVarsFromStd(string(out))
-		msgs := getMessagesFromStd(string(out))
+		//Execute gatherer
+		cmdCtx, cancel := withTimeout(ctx, timeout)
+		cmd := scope.Command(path+"/gathers/"+gatherName, nil, resolveNaming(gather.Naming, naming), gather.Command, gather.Arguments...)
+
+		handler := outputHandler{
+			Var: func(key string, value string) {
+				fmt.Printf("Gatherer found %v = %v\n", key, value)
+				scope.Set(LayerFact, key, value)
+			},
+			Message: func(msg string) {
+				fmt.Printf("MSG: %v\n", msg)
+			},
+			Progress: func(percent int, text string) {
+				fmt.Printf("PROGRESS: %v %v%% %v\n", gatherName, percent, text)
+			},
+		}
+
+		output, err := runWithProtocol(cmdCtx, cmd, gather.Protocol, gather.ProtocolFD, nil, handler)
+		cancel()
 
-		//set environment variables
-		for key, value := range vars {
-			fmt.Printf("Gatherer found %v = %v\n", key, value)
-			os.Setenv(key, value)
+		if err == errTimeout {
+			fmt.Printf("Gatherer %v %v!\n", gatherName, describeTimeout(ctx, timeout))
+			return err
 		}
 
-		for _, msg := range msgs {
-			fmt.Printf("MSG: %v\n", msg)
+		if err == errInterrupted {
+			fmt.Printf("Gatherer %v interrupted!\n", gatherName)
+			return err
+		}
+
+		if err != nil {
+			fmt.Printf("Error running gather!\nStdout: %v\nStderr: %v\nError: %v\n", output.Stdout, output.Stderr, err)
+			return err
 		}
 
-		os.Chdir(curentDir)
 	}
 
 	return nil
 }
 
+//Markers stop at the first ]## so more than one can be on a line. Names stop at the first = so values can have one.
+var varMarker = regexp.MustCompile("##SPANR\\[(.*?)=(.*?)\\]##")
+var msgMarker = regexp.MustCompile("##SPANRMSG\\[(.*?)\\]##")
+
 func getVarsFromStd(text string) map[string]string {
 	returnData := make(map[string]string)
 
-	re := regexp.MustCompile("##SPANR\\[(.*)=(.*)\\]##")
-
-	result := re.FindAllStringSubmatch(text, -1)
+	result := varMarker.FindAllStringSubmatch(text, -1)
 
 	for _, item := range result {
 		returnData[item[1]] = item[2]
@@ -550,9 +686,7 @@ func getVarsFromStd(text string) map[string]string {
 func getMessagesFromStd(text string) []string {
 	var returnData []string
 
-	re := regexp.MustCompile("##SPANRMSG\\[(.*)\\]##")
-
-	result := re.FindAllStringSubmatch(text, -1)
+	result := msgMarker.FindAllStringSubmatch(text, -1)
 
 	for _, item := range result {
 		returnData = append(returnData, item[1])
@@ -588,7 +722,7 @@ func saveResult(path string, config ConfigInfo) error {
 	return nil
 }
 
-func loadProperties(path string) error {
+func loadProperties(path string, scope *VarScope) error {
 	if path == "" {
 		return nil
 	}
@@ -631,13 +765,13 @@ func loadProperties(path string) error {
 
 	for key, value := range results {
 		fmt.Printf("Setting %v = %v\n", key, value)
-		os.Setenv(key, value)
+		scope.Set(LayerProperty, key, value)
 	}
 
 	return nil
 }
 
-func loadRuntimes(path string) error {
+func loadRuntimes(path string, scope *VarScope) error {
 
 	file, err := os.Open(path + "/runtimes.yaml")
 
@@ -679,16 +813,16 @@ func loadRuntimes(path string) error {
 
 	file.Close()
 
-	newPath := os.Getenv("PATH")
+	newPath, _ := scope.Get("PATH")
 
 	for _, rt := range results {
 		fmt.Printf("Adding runtime %v to path\n", rt.Name)
 		for _, p := range rt.Path {
-			newPath = path + "/" + p + ";" + newPath
+			newPath = path + "/" + p + string(os.PathListSeparator) + newPath
 		}
 	}
 
-	os.Setenv("PATH", newPath)
+	scope.Set(LayerRuntime, "PATH", newPath)
 	return nil
 
 }
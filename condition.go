@@ -0,0 +1,619 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//Conditions are small expressions over variables, for example:
+//
+//	os_family == "debian" && kernel_version >= "5.4"
+//	!SKIP_DOCKER && (role in ["web", "app"] || hostname =~ "^web[0-9]+$")
+//
+//A bare variable name is true when the variable is set and not empty, which keeps the
+//original VAR and !VAR conditions working.
+
+//Condition token types
+const (
+	tokEnd    = iota //End of condition
+	tokIdent  = iota //Variable name
+	tokString = iota //Quoted string
+	tokNumber = iota //Unquoted number or version
+	tokOp     = iota //Operator or bracket
+)
+
+type condToken struct {
+	Type int
+	Text string
+	Pos  int
+}
+
+//condNode - A parsed condition that evaluates to true or false
+type condNode interface {
+	eval(lookup func(string) (string, bool)) (bool, error)
+}
+
+//condOperand - A variable or literal used in a comparison
+type condOperand struct {
+	Name    string //Variable name, empty for literals
+	Literal string //Literal value
+}
+
+type orNode struct{ Left, Right condNode }
+type andNode struct{ Left, Right condNode }
+type notNode struct{ Inner condNode }
+type truthNode struct{ Operand condOperand }
+
+type compareNode struct {
+	Op          string
+	Left, Right condOperand
+	re          *regexp.Regexp
+}
+
+type inNode struct {
+	Left condOperand
+	List []condOperand
+}
+
+//parseCondition - Parses a condition expression so it can be evaluated
+func parseCondition(text string) (condNode, error) {
+	tokens, err := lexCondition(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &condParser{tokens: tokens}
+	node, err := p.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.Type != tokEnd {
+		return nil, fmt.Errorf("unexpected %q at position %v", tok.Text, tok.Pos)
+	}
+
+	return node, nil
+}
+
+//evalCondition - Parses and evaluates a condition against variables returned by lookup
+func evalCondition(text string, lookup func(string) (string, bool)) (bool, error) {
+	node, err := parseCondition(text)
+
+	if err != nil {
+		return false, err
+	}
+
+	return node.eval(lookup)
+}
+
+func lexCondition(text string) ([]condToken, error) {
+	var tokens []condToken
+	ops := []string{"&&", "||", "==", "!=", "=~", "!~", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			var value strings.Builder
+			end := i + 1
+
+			for ; end < len(text) && text[end] != c; end++ {
+				if text[end] == '\\' && end+1 < len(text) {
+					end++
+				}
+
+				value.WriteByte(text[end])
+			}
+
+			if end >= len(text) {
+				return nil, fmt.Errorf("unterminated string at position %v", i)
+			}
+
+			tokens = append(tokens, condToken{Type: tokString, Text: value.String(), Pos: i})
+			i = end + 1
+		case c >= '0' && c <= '9':
+			end := i
+
+			for end < len(text) && (isNameChar(text[end], false) || text[end] == '.' || text[end] == '-') {
+				end++
+			}
+
+			tokens = append(tokens, condToken{Type: tokNumber, Text: text[i:end], Pos: i})
+			i = end
+		case isNameChar(c, true):
+			end := i
+
+			//Dots join names, e.g. items.web.state
+			for end < len(text) && (isNameChar(text[end], false) || text[end] == '.' && end+1 < len(text) && isNameChar(text[end+1], false)) {
+				end++
+			}
+
+			tokens = append(tokens, condToken{Type: tokIdent, Text: text[i:end], Pos: i})
+			i = end
+		default:
+			matched := false
+
+			for _, op := range ops {
+				if strings.HasPrefix(text[i:], op) {
+					tokens = append(tokens, condToken{Type: tokOp, Text: op, Pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at position %v", string(c), i)
+			}
+		}
+	}
+
+	return append(tokens, condToken{Type: tokEnd, Pos: len(text)}), nil
+}
+
+type condParser struct {
+	tokens []condToken
+	pos    int
+}
+
+func (p *condParser) peek() condToken {
+	return p.tokens[p.pos]
+}
+
+func (p *condParser) next() condToken {
+	tok := p.tokens[p.pos]
+
+	if tok.Type != tokEnd {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *condParser) accept(op string) bool {
+	if tok := p.peek(); tok.Type == tokOp && tok.Text == op {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	left, err := p.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.parseAnd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = orNode{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	left, err := p.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = andNode{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *condParser) parseUnary() (condNode, error) {
+	if p.accept("!") {
+		inner, err := p.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{Inner: inner}, nil
+	}
+
+	if p.accept("(") {
+		inner, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.accept(")") {
+			tok := p.peek()
+			return nil, fmt.Errorf("expected ) at position %v", tok.Pos)
+		}
+
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *condParser) parseComparison() (condNode, error) {
+	left, err := p.parseOperand()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+
+	if tok.Type == tokIdent && tok.Text == "in" {
+		p.next()
+		list, err := p.parseList()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return inNode{Left: left, List: list}, nil
+	}
+
+	if tok.Type != tokOp {
+		return truthNode{Operand: left}, nil
+	}
+
+	switch tok.Text {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+	default:
+		return truthNode{Operand: left}, nil
+	}
+
+	p.next()
+	right, err := p.parseOperand()
+
+	if err != nil {
+		return nil, err
+	}
+
+	node := compareNode{Op: tok.Text, Left: left, Right: right}
+
+	if (node.Op == "=~" || node.Op == "!~") && right.Name == "" {
+		node.re, err = regexp.Compile(right.Literal)
+
+		if err != nil {
+			return nil, fmt.Errorf("bad regular expression %q: %v", right.Literal, err)
+		}
+	}
+
+	return node, nil
+}
+
+func (p *condParser) parseList() ([]condOperand, error) {
+	if !p.accept("[") {
+		return nil, fmt.Errorf("expected [ at position %v", p.peek().Pos)
+	}
+
+	var list []condOperand
+
+	if p.accept("]") {
+		return list, nil
+	}
+
+	for {
+		item, err := p.parseOperand()
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, item)
+
+		if p.accept("]") {
+			return list, nil
+		}
+
+		if !p.accept(",") {
+			return nil, fmt.Errorf("expected , or ] at position %v", p.peek().Pos)
+		}
+	}
+}
+
+func (p *condParser) parseOperand() (condOperand, error) {
+	tok := p.next()
+
+	switch tok.Type {
+	case tokIdent:
+		return condOperand{Name: tok.Text}, nil
+	case tokString, tokNumber:
+		return condOperand{Literal: tok.Text}, nil
+	case tokEnd:
+		return condOperand{}, fmt.Errorf("unexpected end of condition")
+	default:
+		return condOperand{}, fmt.Errorf("unexpected %q at position %v", tok.Text, tok.Pos)
+	}
+}
+
+func (o condOperand) value(lookup func(string) (string, bool)) string {
+	if o.Name == "" {
+		return o.Literal
+	}
+
+	val, _ := lookup(o.Name)
+
+	return val
+}
+
+func (n orNode) eval(lookup func(string) (string, bool)) (bool, error) {
+	left, err := n.Left.eval(lookup)
+
+	if err != nil || left {
+		return left, err
+	}
+
+	return n.Right.eval(lookup)
+}
+
+func (n andNode) eval(lookup func(string) (string, bool)) (bool, error) {
+	left, err := n.Left.eval(lookup)
+
+	if err != nil || !left {
+		return false, err
+	}
+
+	return n.Right.eval(lookup)
+}
+
+func (n notNode) eval(lookup func(string) (string, bool)) (bool, error) {
+	inner, err := n.Inner.eval(lookup)
+
+	return !inner, err
+}
+
+func (n truthNode) eval(lookup func(string) (string, bool)) (bool, error) {
+	return n.Operand.value(lookup) != "", nil
+}
+
+func (n inNode) eval(lookup func(string) (string, bool)) (bool, error) {
+	left := n.Left.value(lookup)
+
+	for _, item := range n.List {
+		if item.value(lookup) == left {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (n compareNode) eval(lookup func(string) (string, bool)) (bool, error) {
+	left := n.Left.value(lookup)
+	right := n.Right.value(lookup)
+
+	switch n.Op {
+	case "=~", "!~":
+		re := n.re
+
+		if re == nil {
+			var err error
+			re, err = regexp.Compile(right)
+
+			if err != nil {
+				return false, fmt.Errorf("bad regular expression %q: %v", right, err)
+			}
+		}
+
+		return re.MatchString(left) == (n.Op == "=~"), nil
+	}
+
+	switch n.Op {
+	case "==":
+		return equalValues(left, right), nil
+	case "!=":
+		return !equalValues(left, right), nil
+	}
+
+	cmp := compareValues(left, right)
+
+	switch n.Op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+//equalValues - Returns true if a and b are the same text, or are numbers that compareValues finds equal (e.g. 1.0 and 1)
+func equalValues(a string, b string) bool {
+	if isNumber(a) && isNumber(b) {
+		return compareValues(a, b) == 0
+	}
+
+	return a == b
+}
+
+//compareValues - Compares two values as numbers if both are plain numbers, as versions if both are versions,
+//otherwise as strings. Returns -1, 0 or 1. Dotted values are versions (so 5.10 is after 5.4) unless one of
+//them is clearly a decimal like 5.04.
+func compareValues(a string, b string) int {
+	numbers := isNumber(a) && isNumber(b)
+
+	if numbers && (!strings.Contains(a, ".") && !strings.Contains(b, ".") || isDecimal(a) || isDecimal(b)) {
+		return compareFloats(a, b)
+	}
+
+	if va, ok := parseVersion(a); ok {
+		if vb, ok := parseVersion(b); ok {
+			return va.compare(vb)
+		}
+	}
+
+	if numbers {
+		return compareFloats(a, b)
+	}
+
+	return strings.Compare(a, b)
+}
+
+func compareFloats(a string, b string) int {
+	fa, _ := strconv.ParseFloat(a, 64)
+	fb, _ := strconv.ParseFloat(b, 64)
+
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	default:
+		return 0
+	}
+}
+
+//isNumber - Returns true if text is a plain number, digits with an optional sign and decimal point (e.g. -1.5)
+func isNumber(text string) bool {
+	text = strings.TrimLeft(text, "+-")
+	parts := strings.Split(text, ".")
+
+	if len(parts) > 2 {
+		return false
+	}
+
+	for _, part := range parts {
+		if !isDigits(part) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDigits(text string) bool {
+	if text == "" {
+		return false
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] < '0' || text[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+//isDecimal - Returns true if text can't be a version, because it has a part with a leading zero (e.g. 5.04)
+func isDecimal(text string) bool {
+	for _, part := range strings.Split(strings.TrimLeft(text, "+-"), ".") {
+		if len(part) > 1 && part[0] == '0' {
+			return true
+		}
+	}
+
+	return false
+}
+
+//version - A semver style version like 5.4 or v5.4.0. A dotted version can have a suffix after - or +. A suffix
+//after - starting with a letter (e.g. -rc1 or -beta) is a pre-release and comes before the release. Any other
+//suffix (e.g. -91-generic or +build) is build information and is ignored.
+type version struct {
+	Parts      []int
+	PreRelease string
+}
+
+//parseVersion - Parses text as a version. Anything else after the version (e.g. 10.0.0.1/24) means it isn't one.
+func parseVersion(text string) (version, bool) {
+	text = strings.TrimPrefix(text, "v")
+	end := strings.IndexAny(text, "-+")
+
+	if end == -1 {
+		end = len(text)
+	}
+
+	var v version
+
+	for _, part := range strings.Split(text[:end], ".") {
+		if !isDigits(part) {
+			return version{}, false
+		}
+
+		n, err := strconv.Atoi(part)
+
+		if err != nil {
+			return version{}, false
+		}
+
+		v.Parts = append(v.Parts, n)
+	}
+
+	if end == len(text) {
+		return v, true
+	}
+
+	//Only a full dotted version can have a suffix, so dates like 2024-01-05 aren't versions.
+	if len(v.Parts) < 2 || end == len(text)-1 {
+		return version{}, false
+	}
+
+	suffix := text[end+1:]
+
+	if text[end] == '-' && (suffix[0] >= 'a' && suffix[0] <= 'z' || suffix[0] >= 'A' && suffix[0] <= 'Z') {
+		v.PreRelease = suffix
+	}
+
+	return v, true
+}
+
+//compare - Returns -1, 0 or 1 depending on whether v comes before, is the same as or comes after o
+func (v version) compare(o version) int {
+	for i := 0; i < len(v.Parts) || i < len(o.Parts); i++ {
+		a, b := 0, 0
+
+		if i < len(v.Parts) {
+			a = v.Parts[i]
+		}
+
+		if i < len(o.Parts) {
+			b = o.Parts[i]
+		}
+
+		if a != b {
+			if a < b {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	//A pre-release comes before the release it belongs to.
+	switch {
+	case v.PreRelease == o.PreRelease:
+		return 0
+	case v.PreRelease == "":
+		return 1
+	case o.PreRelease == "":
+		return -1
+	default:
+		return strings.Compare(v.PreRelease, o.PreRelease)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import "testing"
+
+func TestEvalConditionVersions(t *testing.T) {
+	tests := []struct {
+		kernel string
+		want   bool
+	}{
+		{"5.10", true},
+		{"5.4.0-91-generic", true},
+		{"6.1", true},
+		{"5.4", true},
+		{"5.4-rc1", false},
+		{"5.3.18", false},
+		{"4.19", false},
+	}
+
+	for _, test := range tests {
+		lookup := func(name string) (string, bool) {
+			if name == "kernel_version" {
+				return test.kernel, true
+			}
+
+			return "", false
+		}
+
+		got, err := evalCondition(`kernel_version >= "5.4"`, lookup)
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.kernel, err)
+		}
+
+		if got != test.want {
+			t.Errorf("%v >= 5.4 = %v, want %v", test.kernel, got, test.want)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"5.10", "5.4", 1},
+		{"5.4.0-91-generic", "5.4", 0},
+		{"5.4.0+build", "5.4.0", 0},
+		{"5.4-rc1", "5.4", -1},
+		{"5.4-rc1", "5.4-rc2", -1},
+		{"5.04", "5.4", -1},
+		{"10", "9", 1},
+		{"-1.5", "2", -1},
+		{"abc", "abd", -1},
+		{"2024-01-05", "2024-02-07", -1},
+		{"5.4.0-91-generic", "5.4.0-42-generic", 0},
+		{"10.0.0.1/24", "10.0.0.1", 1},
+		{"v2", "v10", -1},
+	}
+
+	for _, test := range tests {
+		if got := compareValues(test.a, test.b); got != test.want {
+			t.Errorf("compareValues(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestEvalConditionEquality(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1 apple", "1 pear", false},
+		{"2024-01-05", "2024-02-07", false},
+		{"5.4.0-91-generic", "5.4.0-42-generic", false},
+		{"10.0.0.1/24", "10.0.0.1", false},
+		{"v2", "2", false},
+		{"5.10", "5.1", false},
+		{"web01", "web01", true},
+		{"1.0", "1", true},
+		{"10", "10.0", true},
+		{"-1.5", "-1.50", true},
+	}
+
+	for _, test := range tests {
+		lookup := func(name string) (string, bool) {
+			if name == "a" {
+				return test.a, true
+			}
+
+			return test.b, true
+		}
+
+		for _, op := range []string{"==", "!="} {
+			got, err := evalCondition("a "+op+" b", lookup)
+
+			if err != nil {
+				t.Fatalf("%q %v %q: %v", test.a, op, test.b, err)
+			}
+
+			if want := test.want == (op == "=="); got != want {
+				t.Errorf("%q %v %q = %v, want %v", test.a, op, test.b, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+var errTimeout = errors.New("timed out")
+
+//parseDuration - Parses a duration like "30s" or "5m". An empty string means 0 (i.e. no timeout).
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	return duration, nil
+}
+
+//withTimeout - Returns a context limited by timeout, or just a cancelable ctx when timeout is 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+//runCommand - Runs cmd until it exits. If ctx is done before cmd exits the whole process group is
+//killed and errTimeout is returned. If spanr is interrupted the signal is passed on to the process
+//group, which is killed if it hasn't exited after the grace period, and errInterrupted is returned.
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+
+	if interrupt.Interrupted() {
+		return errInterrupted
+	}
+
+	if ctx.Err() != nil {
+		return errTimeout
+	}
+
+	err := cmd.Start()
+
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return errTimeout
+	case <-interrupt.Done():
+		signalProcessGroup(cmd, interrupt.Signal())
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+			<-done
+		case <-time.After(interruptGrace):
+			killProcessGroup(cmd)
+			<-done
+		}
+
+		return errInterrupted
+	}
+}
+
+//sleepContext - Waits for d. Returns false straight away if ctx is done or spanr is interrupted.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-interrupt.Done():
+		return false
+	}
+}
+
+//describeTimeout - Explains which deadline caused errTimeout, the whole run or the script's own timeout
+func describeTimeout(run context.Context, timeout time.Duration) string {
+	if run.Err() != nil {
+		return "run timed out"
+	}
+
+	return fmt.Sprintf("timed out after %v", timeout)
+}
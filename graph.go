@@ -1,5 +1,11 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 //Configuration State
 const (
 	CFGNotRun         = iota //Config Item not yet run
@@ -8,6 +14,9 @@ const (
 	CFGNotConfigured  = iota //Config Item not configured
 	CFGError          = iota //Config Item error
 	CFGSkipOnDep      = iota //Config Item is skipped due to failed condition (this is not a fail)
+	CFGInterrupted    = iota //Run was interrupted by a signal before it finished
+	CFGNotApplicable  = iota //Config condition is false so nothing in it applies to this system
+	CFGChanged        = iota //Config Item wasn't configured and has now been applied
 )
 
 func printCFG(value int) string {
@@ -24,6 +33,12 @@ func printCFG(value int) string {
 		return "ERROR"
 	case CFGSkipOnDep:
 		return "Skipped Due to Dependancy"
+	case CFGInterrupted:
+		return "Interrupted"
+	case CFGNotApplicable:
+		return "NotApplicable"
+	case CFGChanged:
+		return "Changed"
 	default:
 		return "Unknown"
 	}
@@ -35,23 +50,71 @@ type RunTimeInfo struct {
 	Path []string //List of paths that are in runtime folder to prepend to path on execution.
 }
 
+//RunOptions - Holds the command line settings for a run
+type RunOptions struct {
+	Properties string        //Path to properties file passed in on command line
+	Config     string        //Path to an alternative config file
+	Test       bool          //Only run tests, don't apply anything
+	Strict     bool          //Treat options a resource doesn't declare as errors instead of warnings
+	Parallel   int           //Maximum number of config items that are run at the same time
+	Timeout    time.Duration //Maximum time the whole run may take, 0 for no limit
+	LogDir     string        //Folder to write a log file for each item and phase to, empty for no logs
+	Naming     string        //Default naming for resources and gatherers that don't set their own
+}
+
 //ConfigInfo - Holds A configuration script
 type ConfigInfo struct {
-	Name        string       //Name of configuration script
-	Author      string       //Who created the script
-	Version     string       //Version of script
-	Description string       //Description of script
-	Items       []ConfigItem //All the configuration items in script
-	Condition   string       //only apply if environment variable is set. Use ! to invert it.
+	Name        string        //Name of configuration script
+	Author      string        //Who created the script
+	Version     string        //Version of script
+	Description string        //Description of script
+	Items       []ConfigItem  //All the configuration items in script
+	Handlers    []ConfigItem  //Items that only run, once at the end, when an item that notifies them changed
+	Include     []string      //Other yaml files (or globs) to add the items and handlers of, relative to this one
+	Condition   string        //only apply if condition is true, either a variable name (use ! to invert it) or an expression.
+	OnFailure   FailurePolicy `yaml:"on_failure"` //What to do when an item fails: stop, continue or max_failures: N
+	State       int           //Overall state of the run, set once the config has been run
 }
 
 //ConfigItem - Holds the definition of a configuration item
 type ConfigItem struct {
-	Name      string            //Unique name of configuration item, used to identify it.
-	Resource  string            //Name of resource this configuration item uses.
-	Condition string            //Conditional used to dermine if item is run or not. Use environment variable name or ! to test inverse.
-	Options   map[string]string //A hash map of configuration settings passed to resource script
-	State     int               //Contains the current state of config item
+	Name         string                 //Unique name of configuration item, used to identify it.
+	Resource     string                 //Name of resource this configuration item uses.
+	Module       string                 `yaml:"module,omitempty"` //Folder of another config to run as part of this one instead of a resource. Options are passed to it as properties.
+	Condition    string                 //Conditional used to dermine if item is run or not. Use environment variable name or ! to test inverse, or an expression.
+	PreReq       []string               //Names of other configuration items that must be configured before this one is run.
+	Notify       []string               //Names of handlers to run if this item changes something
+	Register     string                 //Name to store the item's results under for later items, e.g. ${items.name.state}
+	Timeout      string                 //Maximum time each test or apply script may run (e.g. 30s, 5m). Overrides the resource timeout.
+	IgnoreErrors bool                   `yaml:"ignore_errors"` //Item failing doesn't stop the run or count as a failure
+	Retries      int                    //Number of times to retry the test/apply cycle if it errors
+	Delay        string                 //Time to wait before the first retry, also how often until re-runs the test (e.g. 10s)
+	Backoff      float64                //Multiplies the delay after each retry (e.g. 2 doubles it)
+	Until        string                 //Keep re-running the test after apply until it reports configured or this time passes (e.g. 5m)
+	Options      map[string]interface{} //Configuration settings passed to resource script. Values can be lists and maps.
+	Loop         interface{}            `yaml:"loop,omitempty"` //List of values, or a variable holding one, to run the item once for each
+	LoopItem     interface{}            `yaml:"item,omitempty"` //Value of the loop this item was created for, available as ${item}
+	IsLoop       bool                   `yaml:"-"`              //Set when the item was created by a loop
+	Source       string                 `yaml:"-"`              //File the item was loaded from
+	After        []string               `yaml:"-"`              //Items this one waits for without needing them to succeed, found from its items.<name> references
+	module       *moduleInfo            //Module the item came from, nil if it isn't from one
+	State        int                    //Contains the current state of config item
+	Reason       string                 //Why the config item ended up in an error or skipped state
+	Attempts     []AttemptInfo          //Every script run for this item and what it returned
+	Vars         map[string]string      `yaml:"vars,omitempty"`     //Variables the item's scripts set
+	Messages     []string               `yaml:"messages,omitempty"` //Messages the item's scripts printed
+}
+
+//AttemptInfo - Holds the result of running one of a config item's scripts
+type AttemptInfo struct {
+	Attempt  int    //Which try of the item's test/apply cycle this was, starting at 1
+	Phase    string //test, apply or retest
+	State    int    //State the script reported
+	Reason   string //Why the script failed, if it did
+	Started  string //Time the script was started
+	Duration string //How long the script ran for
+	Stdout   string //What the script wrote to stdout
+	Stderr   string //What the script wrote to stderr
 }
 
 //GatherInfo - Holds info on gatherer
@@ -62,18 +125,136 @@ type GatherInfo struct {
 	Version     string   //Version of gatherer
 	Command     string   //Command to be run by gatherer
 	Arguments   []string //Arguments to be passed to gatherer
+	Timeout     string   //Maximum time gatherer may run (e.g. 30s, 5m)
+	Protocol    string   //How gatherer reports back: markers (default) or json
+	ProtocolFD  bool     `yaml:"protocol_fd"` //Read the protocol from file descriptor 3 instead of stdout
+	Naming      string   //How variables are named in the gatherer's environment: both, prefixed or bare
 }
 
 //ResourceInfo - Holds info on a resource
 type ResourceInfo struct {
-	Name           string          //Unique name of resource
-	Description    string          //Description of resource
-	Author         string          //Author Author of resource
-	Version        string          //Version of resource
-	TestCommand    string          //Command to run for resource test
-	ApplyCommand   string          //Command to run for resource apply
-	TestArguments  []string        //Arguments to run when testing resource
-	ApplyArguments []string        //Arguments to run when applying resource
-	Properties     map[string]bool //Properties resource supports. Boolean specifies if property is mandatory or not
-	Path           string          //Set by loader to the directory of the resource files.
+	Name           string                  //Unique name of resource
+	Description    string                  //Description of resource
+	Author         string                  //Author Author of resource
+	Version        string                  //Version of resource
+	TestCommand    string                  //Command to run for resource test
+	ApplyCommand   string                  //Command to run for resource apply
+	TestArguments  []string                //Arguments to run when testing resource
+	ApplyArguments []string                //Arguments to run when applying resource
+	Properties     map[string]PropertyInfo //Properties resource supports. Can be just a boolean that specifies if property is mandatory or not
+	Timeout        string                  //Default maximum time each test or apply script may run (e.g. 30s, 5m)
+	Protocol       string                  //How scripts report back: markers (default) or json
+	ProtocolFD     bool                    `yaml:"protocol_fd"` //Read the protocol from file descriptor 3 instead of stdout
+	ExitCodes      map[int]string          //For the exitcode protocol, maps exit codes to configured, notconfigured, reboot or fail
+	Input          string                  //How options are passed to scripts: env (default) or json on stdin
+	Naming         string                  //How variables are named in the scripts' environment: both, prefixed or bare
+	Path           string                  //Set by loader to the directory of the resource files.
+}
+
+//orderConfigItems - Returns the indexes of items ordered so every item comes after its prerequisites.
+//Items without a dependency between them keep the order they appear in the config file.
+func orderConfigItems(items []ConfigItem) ([]int, error) {
+	index := make(map[string]int)
+
+	for i, item := range items {
+		if _, ok := index[item.Name]; ok {
+			return nil, fmt.Errorf("config item name %v is used more than once", item.Name)
+		}
+
+		index[item.Name] = i
+	}
+
+	for _, item := range items {
+		for _, p := range item.dependencies() {
+			if _, ok := index[p]; !ok {
+				return nil, fmt.Errorf("config item %v has unknown prerequisite %v", item.Name, p)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	marks := make([]int, len(items))
+	order := make([]int, 0, len(items))
+	var stack []int
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("prerequisite cycle detected: %v", describeCycle(items, stack, i))
+		}
+
+		marks[i] = visiting
+		stack = append(stack, i)
+
+		for _, p := range items[i].dependencies() {
+			if err := visit(index[p]); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		marks[i] = visited
+		order = append(order, i)
+
+		return nil
+	}
+
+	for i := range items {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+//dependencies - Returns the names of the items item waits for, its prerequisites and the items it only runs after
+func (item ConfigItem) dependencies() []string {
+	return append(append([]string{}, item.PreReq...), item.After...)
+}
+
+//describeCycle - Formats the part of the visit stack that loops back to item i, e.g. "a -> b -> a".
+func describeCycle(items []ConfigItem, stack []int, i int) string {
+	start := 0
+
+	for n, s := range stack {
+		if s == i {
+			start = n
+			break
+		}
+	}
+
+	names := make([]string, 0, len(stack)-start+1)
+
+	for _, s := range stack[start:] {
+		names = append(names, items[s].Name)
+	}
+
+	names = append(names, items[i].Name)
+
+	return strings.Join(names, " -> ")
+}
+
+//blockedPreReq - Returns the name of the first prerequisite of item that didn't end up configured or changed, or "" if it can run.
+//In test mode a prerequisite that is only not configured doesn't block, so dependents still get tested.
+func blockedPreReq(item ConfigItem, states map[string]int, test bool) string {
+	for _, p := range item.PreReq {
+		state := states[p]
+
+		if state == CFGConfigured || state == CFGChanged || (test && state == CFGNotConfigured) {
+			continue
+		}
+
+		return p
+	}
+
+	return ""
 }
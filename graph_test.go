@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrderConfigItems(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []ConfigItem
+		order []int
+		err   string
+	}{
+		{
+			name:  "config order without prerequisites",
+			items: []ConfigItem{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			order: []int{0, 1, 2},
+		},
+		{
+			name:  "prerequisites first",
+			items: []ConfigItem{{Name: "c", PreReq: []string{"b"}}, {Name: "b", PreReq: []string{"a"}}, {Name: "a"}},
+			order: []int{2, 1, 0},
+		},
+		{
+			name:  "after orders like a prerequisite",
+			items: []ConfigItem{{Name: "b", After: []string{"a"}}, {Name: "a"}},
+			order: []int{1, 0},
+		},
+		{
+			name:  "cycle",
+			items: []ConfigItem{{Name: "a", PreReq: []string{"b"}}, {Name: "b", PreReq: []string{"a"}}},
+			err:   "prerequisite cycle detected: a -> b -> a",
+		},
+		{
+			name:  "cycle part way down",
+			items: []ConfigItem{{Name: "a", PreReq: []string{"b"}}, {Name: "b", PreReq: []string{"c"}}, {Name: "c", After: []string{"b"}}},
+			err:   "prerequisite cycle detected: b -> c -> b",
+		},
+		{
+			name:  "item waits for itself",
+			items: []ConfigItem{{Name: "a", PreReq: []string{"a"}}},
+			err:   "prerequisite cycle detected: a -> a",
+		},
+		{
+			name:  "unknown prerequisite",
+			items: []ConfigItem{{Name: "a", PreReq: []string{"missing"}}},
+			err:   "config item a has unknown prerequisite missing",
+		},
+		{
+			name:  "duplicate name",
+			items: []ConfigItem{{Name: "a"}, {Name: "a"}},
+			err:   "config item name a is used more than once",
+		},
+	}
+
+	for _, test := range tests {
+		order, err := orderConfigItems(test.items)
+
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: error %v, want %v", test.name, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(order, test.order) {
+			t.Errorf("%v: order %v, want %v", test.name, order, test.order)
+		}
+	}
+}
+
+func TestBlockedPreReq(t *testing.T) {
+	item := ConfigItem{Name: "x", PreReq: []string{"a", "b"}, After: []string{"c"}}
+
+	tests := []struct {
+		states map[string]int
+		test   bool
+		want   string
+	}{
+		{map[string]int{"a": CFGConfigured, "b": CFGChanged, "c": CFGError}, false, ""},
+		{map[string]int{"a": CFGConfigured, "b": CFGError}, false, "b"},
+		{map[string]int{"a": CFGSkipOnDep, "b": CFGError}, false, "a"},
+		{map[string]int{"a": CFGNotConfigured, "b": CFGConfigured}, false, "a"},
+		{map[string]int{"a": CFGNotConfigured, "b": CFGConfigured}, true, ""},
+		{map[string]int{"a": CFGRebootRequired, "b": CFGConfigured}, true, "a"},
+		{map[string]int{"a": CFGConfigured, "b": CFGInterrupted}, false, "b"},
+	}
+
+	for _, test := range tests {
+		if got := blockedPreReq(item, test.states, test.test); got != test.want {
+			t.Errorf("blockedPreReq with %v (test %v) = %q, want %q", test.states, test.test, got, test.want)
+		}
+	}
+}
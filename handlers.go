@@ -0,0 +1,79 @@
+package main
+
+import "fmt"
+
+//runHandlers - Runs the handlers notified by items that changed, once each, after the items have run.
+//Prerequisites between handlers only order the ones that were notified. Handlers nothing notified are
+//left CFGNotRun. Returns CFGNotRun if no handler was notified, otherwise the same as scheduleItems.
+func runHandlers(cfg *ConfigInfo, workers int, process func(item *ConfigItem) int, finished func(item *ConfigItem)) int {
+	notified := make(map[string]bool)
+
+	for _, item := range cfg.Items {
+		if item.State != CFGChanged {
+			continue
+		}
+
+		for _, h := range item.Notify {
+			notified[h] = true
+		}
+	}
+
+	handlers := ConfigInfo{OnFailure: cfg.OnFailure}
+	var index []int
+
+	for i, h := range cfg.Handlers {
+		if !notified[h.Name] {
+			cfg.Handlers[i].Reason = "not notified"
+			continue
+		}
+
+		h.PreReq = notifiedNames(h.PreReq, notified)
+		h.After = notifiedNames(h.After, notified)
+		handlers.Items = append(handlers.Items, h)
+		index = append(index, i)
+	}
+
+	if len(handlers.Items) == 0 {
+		return CFGNotRun
+	}
+
+	order, err := orderConfigItems(handlers.Items)
+
+	if err != nil {
+		fmt.Printf("Failed to order handlers!\nError: %v\n", err)
+		return CFGError
+	}
+
+	fmt.Println("Running handlers:")
+
+	result := scheduleItems(&handlers, order, workers, false, process, finished)
+
+	for n, i := range index {
+		prereqs, after := cfg.Handlers[i].PreReq, cfg.Handlers[i].After
+		cfg.Handlers[i] = handlers.Items[n]
+		cfg.Handlers[i].PreReq = prereqs
+		cfg.Handlers[i].After = after
+	}
+
+	return result
+}
+
+func notifiedNames(names []string, notified map[string]bool) []string {
+	var kept []string
+
+	for _, name := range names {
+		if notified[name] {
+			kept = append(kept, name)
+		}
+	}
+
+	return kept
+}
+
+//allItems - Returns the config's items followed by its handlers
+func (c ConfigInfo) allItems() []ConfigItem {
+	items := make([]ConfigItem, 0, len(c.Items)+len(c.Handlers))
+	items = append(items, c.Items...)
+
+	return append(items, c.Handlers...)
+}
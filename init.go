@@ -89,15 +89,15 @@ name: "Name of config here"
 author: "Your name here"
 description: "Your script description here"
 version: "0.1.0"
-Items:
+items:
 # Fill out configuration items here
-- Name: MyConfig #Name must be unique for each item
-Resource: MyResource #Name of resource that this item uses.
-Condition: VarName1 #Variable that must be true to run this (put ! at front for false)
-PreReq: [ "MyConfig2", "MyConfig3" ] # List of other configuration items that must be run before this one
-Options: #Options for the resource this action applies to.
-  Op1: "5" #You can put variables in options by putting
-  Op2: "123" #$VARNAME or ${VARNAME}`)
+- name: MyConfig #Name must be unique for each item
+  resource: MyResource #Name of resource that this item uses.
+  condition: VarName1 #Variable that must be true to run this (put ! at front for false) or an expression like os == "linux"
+  prereq: [ "MyConfig2", "MyConfig3" ] # List of other configuration items that must be run before this one
+  options: #Options for the resource this action applies to.
+    Op1: "5" #You can put variables in options by putting
+    Op2: "123" #$VARNAME or ${VARNAME}`)
 
 	if err != nil {
 		fmt.Println("Failed to write to config.yaml!")
@@ -122,6 +122,7 @@ Options: #Options for the resource this action applies to.
 	_, err = file.WriteString(`
 # Put a list of properties that can be passed into a configuration
 # Have to be key value pairs and will end up as environment variables
+# named VARNAME and SPANR_CFG_VARNAME
 MyProperty: 'MyValue'
 MyOtherProperty: 'MyOtherValue'
 `)
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+//Ways options can be passed to a resource's scripts
+const (
+	InputEnv  = "env"  //Options are set as environment variables (default)
+	InputJSON = "json" //A json document is written to stdin instead
+)
+
+//scriptInput - The json document written to stdin of scripts for resources using input: json
+type scriptInput struct {
+	Item       string                 `json:"item"`       //Name of the config item
+	Resource   string                 `json:"resource"`   //Name of the resource
+	Phase      string                 `json:"phase"`      //test, apply or retest
+	Options    map[string]interface{} `json:"options"`    //Item options with variables expanded and defaults filled in
+	Properties map[string]string      `json:"properties"` //Values from properties.yaml
+	Facts      map[string]string      `json:"facts"`      //Values found by gatherers
+	Vars       map[string]string      `json:"vars"`       //Values set by earlier scripts this run
+}
+
+func validateInput(input string) error {
+	switch input {
+	case "", InputEnv, InputJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown input %q, must be %v or %v", input, InputEnv, InputJSON)
+	}
+}
+
+//setScriptInput - Writes the json document for phase to cmd's stdin
+func (r *itemRun) setScriptInput(cmd *exec.Cmd, phase string) error {
+	data, err := json.Marshal(scriptInput{
+		Item:       r.item.Name,
+		Resource:   r.resource.Name,
+		Phase:      phase,
+		Options:    r.options,
+		Properties: r.scope.Layer(LayerProperty),
+		Facts:      r.scope.Layer(LayerFact),
+		Vars:       r.scope.Layer(LayerSession),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdin = bytes.NewReader(data)
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+//expandVars - Expands $VAR, ${VAR}, ${VAR:-default} and ${VAR:?message} in text using lookup.
+//Use $$ for a literal $. Variables that aren't set expand to nothing and are returned in unresolved.
+//${VAR:?message} returns an error with message if VAR is unset or empty.
+func expandVars(text string, lookup func(string) (string, bool)) (result string, unresolved []string, err error) {
+	var out strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != '$' || i+1 == len(text) {
+			out.WriteByte(text[i])
+			continue
+		}
+
+		next := text[i+1]
+
+		switch {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+		case next == '{':
+			end := matchingBrace(text, i+1)
+
+			if end == -1 {
+				return "", nil, fmt.Errorf("missing } in %q", text)
+			}
+
+			val, missing, err := expandBraced(text[i+2:end], lookup)
+
+			if err != nil {
+				return "", nil, err
+			}
+
+			out.WriteString(val)
+			unresolved = append(unresolved, missing...)
+			i = end
+		case isNameChar(next, true):
+			end := i + 1
+
+			for end < len(text) && isNameChar(text[end], false) {
+				end++
+			}
+
+			name := text[i+1 : end]
+			val, ok := lookup(name)
+
+			if !ok {
+				unresolved = append(unresolved, name)
+			}
+
+			out.WriteString(val)
+			i = end - 1
+		default:
+			out.WriteByte('$')
+		}
+	}
+
+	return out.String(), unresolved, nil
+}
+
+//expandBraced - Expands the inside of a ${...} reference
+func expandBraced(expr string, lookup func(string) (string, bool)) (string, []string, error) {
+	name := expr
+	op := ""
+	arg := ""
+
+	if n := strings.Index(expr, ":"); n != -1 && n+1 < len(expr) && (expr[n+1] == '-' || expr[n+1] == '?') {
+		name = expr[:n]
+		op = expr[n : n+2]
+		arg = expr[n+2:]
+	}
+
+	if !isDottedName(name) {
+		return "", nil, fmt.Errorf("bad variable reference ${%v}", expr)
+	}
+
+	val, ok := lookup(name)
+
+	switch op {
+	case ":-":
+		if val == "" {
+			return expandVars(arg, lookup)
+		}
+	case ":?":
+		if val == "" {
+			msg, _, err := expandVars(arg, lookup)
+
+			if err != nil {
+				return "", nil, err
+			}
+
+			if msg == "" {
+				msg = "not set"
+			}
+
+			return "", nil, fmt.Errorf("%v: %v", name, msg)
+		}
+	default:
+		if !ok {
+			return "", []string{name}, nil
+		}
+	}
+
+	return val, nil, nil
+}
+
+//matchingBrace - Returns the index of the } closing the { at open, allowing nested ${...} in defaults
+func matchingBrace(text string, open int) int {
+	depth := 0
+
+	for i := open; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func isName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//isDottedName - Returns true if name is a name or names joined with dots (e.g. items.web.state).
+//Parts after the first can start with a digit.
+func isDottedName(name string) bool {
+	parts := strings.Split(name, ".")
+
+	if !isName(parts[0]) {
+		return false
+	}
+
+	for _, part := range parts[1:] {
+		if !isName("_" + part) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//hasVarRefs - Returns true if text contains anything expandVars would replace
+func hasVarRefs(text string) bool {
+	for i := 0; i+1 < len(text); i++ {
+		if text[i] == '$' && (text[i+1] == '{' || text[i+1] == '$' || isNameChar(text[i+1], true)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//expandOptions - Expands variable references in every option value using lookup.
+//Returns a description of every reference to a variable that isn't set.
+func expandOptions(options map[string]interface{}, lookup func(string) (string, bool)) (map[string]interface{}, []string, error) {
+	expanded := make(map[string]interface{})
+	var unresolved []string
+
+	for _, name := range sortedKeys(options) {
+		val, missing, err := expandValue(options[name], lookup)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("option %v: %v", name, err)
+		}
+
+		expanded[name] = val
+
+		for _, m := range missing {
+			unresolved = append(unresolved, fmt.Sprintf("option %v uses unset variable %v", name, m))
+		}
+	}
+
+	return expanded, unresolved, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"HOME":             "/home/user",
+		"EMPTY":            "",
+		"items.web.state":  "changed",
+		"items.web.vars.0": "first",
+	}
+
+	lookup := func(name string) (string, bool) {
+		val, ok := vars[name]
+		return val, ok
+	}
+
+	tests := []struct {
+		text       string
+		want       string
+		unresolved []string
+		err        string
+	}{
+		{text: "plain text", want: "plain text"},
+		{text: "$HOME/bin", want: "/home/user/bin"},
+		{text: "${HOME}bin", want: "/home/userbin"},
+		{text: "$$HOME", want: "$HOME"},
+		{text: "$$$HOME", want: "$/home/user"},
+		{text: "cost $5", want: "cost $5"},
+		{text: "ends with $", want: "ends with $"},
+		{text: "$MISSING/x", want: "/x", unresolved: []string{"MISSING"}},
+		{text: "${MISSING}${OTHER}", want: "", unresolved: []string{"MISSING", "OTHER"}},
+		{text: "${MISSING:-default}", want: "default"},
+		{text: "${EMPTY:-default}", want: "default"},
+		{text: "${HOME:-default}", want: "/home/user"},
+		{text: "${MISSING:-}", want: ""},
+		{text: "${MISSING:-$HOME}", want: "/home/user"},
+		{text: "${MISSING:-${HOME}/x}", want: "/home/user/x"},
+		{text: "${MISSING:-${ALSO:-${HOME}}}", want: "/home/user"},
+		{text: "${MISSING:-${NOPE}}", want: "", unresolved: []string{"NOPE"}},
+		{text: "${MISSING:-{x}}", want: "{x}"},
+		{text: "${HOME:?needs a home}", want: "/home/user"},
+		{text: "${MISSING:?needs a value}", err: "MISSING: needs a value"},
+		{text: "${EMPTY:?}", err: "EMPTY: not set"},
+		{text: "${MISSING:?no $HOME}", err: "MISSING: no /home/user"},
+		{text: "${items.web.state}", want: "changed"},
+		{text: "${items.web.vars.0}", want: "first"},
+		{text: "$items.web.state", want: ".web.state", unresolved: []string{"items"}},
+		{text: "${HOME", err: "missing }"},
+		{text: "${MISSING:-${HOME}", err: "missing }"},
+		{text: "${bad name}", err: "bad variable reference ${bad name}"},
+		{text: "${}", err: "bad variable reference ${}"},
+		{text: "${HOME:+x}", err: "bad variable reference ${HOME:+x}"},
+	}
+
+	for _, test := range tests {
+		got, unresolved, err := expandVars(test.text, lookup)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expandVars(%q) error = %v, want %v", test.text, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expandVars(%q): %v", test.text, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("expandVars(%q) = %q, want %q", test.text, got, test.want)
+		}
+
+		if !reflect.DeepEqual(unresolved, test.unresolved) {
+			t.Errorf("expandVars(%q) unresolved = %v, want %v", test.text, unresolved, test.unresolved)
+		}
+	}
+}
+
+func TestHasVarRefs(t *testing.T) {
+	tests := map[string]bool{
+		"plain":        false,
+		"cost $5":      false,
+		"ends with $":  false,
+		"$HOME":        true,
+		"${HOME}":      true,
+		"$$":           true,
+		"a ${B:-c} d":  true,
+		"${items.x.y}": true,
+	}
+
+	for text, want := range tests {
+		if got := hasVarRefs(text); got != want {
+			t.Errorf("hasVarRefs(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+//How often an item with until set re-runs its test when it has no delay of its own
+const defaultPollDelay = 5 * time.Second
+
+//itemRun - Holds what is needed to run the scripts of a config item
+type itemRun struct {
+	ctx      context.Context
+	item     *ConfigItem
+	resource ResourceInfo
+	options  map[string]interface{}
+	scope    *VarScope
+	logDir   string
+	naming   string
+	handler  bool
+	attempt  int
+}
+
+//retry - Runs the test/apply cycle, retrying it when it errors for as many times as the item allows
+func (r *itemRun) retry(test bool) int {
+	delay, _ := parseDuration(r.item.Delay)
+	backoff := r.item.Backoff
+
+	if backoff < 1 {
+		backoff = 1
+	}
+
+	for {
+		r.attempt++
+		r.item.Reason = ""
+		state := r.cycle(test)
+
+		if state != CFGError || r.attempt > r.item.Retries || interrupt.Interrupted() {
+			return state
+		}
+
+		fmt.Printf("Retrying %v in %v (attempt %v of %v)\n", r.item.Name, delay, r.attempt+1, r.item.Retries+1)
+
+		if !sleepContext(r.ctx, delay) {
+			return state
+		}
+
+		delay = time.Duration(float64(delay) * backoff)
+	}
+}
+
+//cycle - Tests the item and, unless only testing, applies it if needed and tests it again.
+//Handlers skip the first test, being notified is what says they need to run.
+func (r *itemRun) cycle(test bool) int {
+	state := CFGNotConfigured
+
+	if !r.handler {
+		state = r.test("test")
+	}
+
+	if test || state == CFGConfigured || state == CFGError || state == CFGRebootRequired || state == CFGNotRun || state == CFGInterrupted {
+		return state
+	}
+
+	applyState := r.apply()
+
+	if applyState == CFGNotRun || applyState == CFGRebootRequired || applyState == CFGError || applyState == CFGInterrupted {
+		return applyState
+	}
+
+	//An apply that says the item still isn't configured failed, e.g. an exit code of 1 with the default mapping.
+	if applyState == CFGNotConfigured {
+		if r.item.Reason == "" {
+			r.item.Reason = "apply reported not configured"
+		}
+
+		return CFGError
+	}
+
+	state = r.retest()
+
+	if state == CFGNotConfigured {
+		if r.item.Reason == "" {
+			r.item.Reason = "still not configured after apply"
+		}
+
+		return CFGError
+	}
+
+	if state == CFGConfigured {
+		return CFGChanged
+	}
+
+	return state
+}
+
+//retest - Tests the item after it has been applied. With until set the test is re-run until it
+//reports configured or the until time has passed.
+func (r *itemRun) retest() int {
+	state := r.test("retest")
+	until, _ := parseDuration(r.item.Until)
+
+	if until == 0 {
+		return state
+	}
+
+	delay, _ := parseDuration(r.item.Delay)
+
+	if delay == 0 {
+		delay = defaultPollDelay
+	}
+
+	deadline := time.Now().Add(until)
+
+	for state != CFGConfigured && state != CFGRebootRequired && time.Now().Add(delay).Before(deadline) {
+		if !sleepContext(r.ctx, delay) {
+			return state
+		}
+
+		state = r.test("retest")
+	}
+
+	if state != CFGConfigured && state != CFGRebootRequired {
+		r.item.Reason = fmt.Sprintf("not configured after %v", until)
+	}
+
+	return state
+}
+
+func (r *itemRun) test(phase string) int {
+	return r.script(phase, r.resource.TestCommand, r.resource.TestArguments)
+}
+
+func (r *itemRun) apply() int {
+	return r.script("apply", r.resource.ApplyCommand, r.resource.ApplyArguments)
+}
+
+//script - Runs one of the resource's scripts with the item's options as extra environment, records
+//the attempt on the item and returns the state the script reported. The item's timeout is used if set,
+//otherwise the resource's default timeout.
+func (r *itemRun) script(phase string, command string, args []string) int {
+	started := time.Now()
+
+	log, err := openScriptLog(r.logDir, r.item.Name, phase)
+
+	if err != nil {
+		fmt.Printf("WARNING: can't write log for %v: %v\n", r.item.Name, err)
+	}
+
+	log.Printf("attempt %v %v started: %v %v", r.attempt, phase, command, strings.Join(args, " "))
+
+	output, reason := r.runScript(phase, command, args, log)
+	duration := time.Since(started).Round(time.Millisecond)
+
+	if reason != "" {
+		r.item.Reason = reason
+		log.Printf("%v", reason)
+	}
+
+	log.Printf("attempt %v %v finished with state %v in %v", r.attempt, phase, printCFG(output.State), duration)
+	log.Close()
+
+	r.item.Attempts = append(r.item.Attempts, AttemptInfo{
+		Attempt:  r.attempt,
+		Phase:    phase,
+		State:    output.State,
+		Reason:   reason,
+		Started:  started.Format(time.RFC3339),
+		Duration: duration.String(),
+		Stdout:   output.Stdout,
+		Stderr:   output.Stderr,
+	})
+
+	for key, val := range output.Vars {
+		if r.item.Vars == nil {
+			r.item.Vars = make(map[string]string)
+		}
+
+		r.item.Vars[key] = val
+	}
+
+	r.item.Messages = append(r.item.Messages, output.Messages...)
+
+	return output.State
+}
+
+//runScript - Runs a script and returns what it reported, along with why it failed if it did
+func (r *itemRun) runScript(phase string, command string, args []string, log *scriptLog) (scriptOutput, string) {
+	timeout, err := parseDuration(r.item.Timeout)
+
+	if err == nil && timeout == 0 {
+		timeout, err = parseDuration(r.resource.Timeout)
+	}
+
+	if err != nil {
+		fmt.Printf("Can't %v %v: %v\n", phase, r.item.Name, err)
+		return scriptOutput{State: CFGError}, err.Error()
+	}
+
+	cmdCtx, cancel := withTimeout(r.ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+
+	if r.resource.Input == InputJSON {
+		//Options only go on stdin so they can't clash with real environment variables.
+		cmd = r.scope.Command(r.resource.Path, nil, r.naming, command, args...)
+
+		if err := r.setScriptInput(cmd, phase); err != nil {
+			fmt.Printf("Can't %v %v: %v\n", phase, r.item.Name, err)
+			return scriptOutput{State: CFGError}, err.Error()
+		}
+	} else {
+		cmd = r.scope.Command(r.resource.Path, exportOptions(r.options), r.naming, command, args...)
+	}
+
+	handler := outputHandler{
+		Var: func(key string, value string) {
+			r.scope.Set(LayerSession, key, value)
+			fmt.Printf("Setting Var %v = %v\n", key, value)
+		},
+		Message: func(msg string) {
+			fmt.Printf("MSG: %v\n", msg)
+		},
+		Progress: func(percent int, text string) {
+			fmt.Printf("PROGRESS: %v %v %v%% %v\n", r.item.Name, phase, percent, text)
+		},
+	}
+
+	output, err := runWithProtocol(cmdCtx, cmd, r.resource.Protocol, r.resource.ProtocolFD, log, handler)
+
+	if err == errInterrupted {
+		reason := fmt.Sprintf("%v interrupted", phase)
+		fmt.Printf("Failed to %v resource\nError: %v\n", phase, reason)
+		output.State = CFGInterrupted
+		return output, reason
+	}
+
+	if err == errTimeout {
+		reason := fmt.Sprintf("%v %v", phase, describeTimeout(r.ctx, timeout))
+		fmt.Printf("Failed to %v resource\nError: %v\n", phase, reason)
+		output.State = CFGError
+		return output, reason
+	}
+
+	if r.resource.Protocol == ProtocolExitCode {
+		code := 0
+
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			code = exitErr.ExitCode()
+			err = nil
+		}
+
+		state, mapped := exitCodeState(r.resource, code)
+		output.State = state
+
+		if err == nil && !mapped {
+			fmt.Printf("Failed to %v resource\nStdout: %v\nStderr: %v\nExit code: %v\n", phase, output.Stdout, output.Stderr, code)
+			return output, fmt.Sprintf("%v exited with unmapped code %v", phase, code)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Failed to %v resource\nStdout: %v\nStderr: %v\nError: %v\n", phase, output.Stdout, output.Stderr, err)
+		output.State = CFGError
+		return output, fmt.Sprintf("%v failed: %v", phase, err)
+	}
+
+	return output, ""
+}
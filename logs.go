@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Longest line written to the log, longer lines are split
+const maxLogLine = 64 * 1024
+
+//scriptLog - Writes a script's output to a log file with every line timestamped.
+//A nil scriptLog discards everything so callers don't have to check if logging is on.
+type scriptLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	streams []*lineWriter
+}
+
+//openScriptLog - Opens (appending to) the log for an item's phase in dir. Returns nil if dir is empty.
+func openScriptLog(dir string, item string, phase string) (*scriptLog, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, logFileName(item)+"."+phase+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &scriptLog{file: file}, nil
+}
+
+//logFileName - Replaces anything in an item name that isn't safe in a file name. If anything was replaced a
+//short hash of the name is added, so names like web/one and web_one don't share a log.
+func logFileName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+
+		return '_'
+	}, name)
+
+	if safe == name {
+		return safe
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+
+	return fmt.Sprintf("%v-%08x", safe, hash.Sum32())
+}
+
+//Printf - Writes a timestamped line from spanr itself to the log
+func (l *scriptLog) Printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+
+	l.write("spanr", fmt.Sprintf(format, args...))
+}
+
+//Stream - Returns a writer that logs each line written to it under name (e.g. stdout)
+func (l *scriptLog) Stream(name string) io.Writer {
+	if l == nil {
+		return ioutil.Discard
+	}
+
+	stream := newLineWriter(maxLogLine, func(line string) {
+		l.write(name, line)
+	})
+
+	l.streams = append(l.streams, stream)
+
+	return stream
+}
+
+//Close - Writes out any partial lines and closes the log file
+func (l *scriptLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	for _, s := range l.streams {
+		s.Flush()
+	}
+
+	return l.file.Close()
+}
+
+func (l *scriptLog) write(stream string, line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	fmt.Fprintf(l.file, "%v [%v] %v\n", time.Now().Format(time.RFC3339Nano), stream, line)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//expandLoops - Replaces every item with a loop by one copy of it for each value, named like users[alice].
+//Items that had the looped item as a prerequisite wait for all of its copies instead.
+//Returns false if a loop couldn't be expanded, the item is marked as an error.
+func expandLoops(cfg *ConfigInfo, scope *VarScope) bool {
+	var items []ConfigItem
+	expanded := make(map[string][]string)
+	ok := true
+
+	for _, item := range cfg.Items {
+		if item.Loop == nil {
+			items = append(items, item)
+			continue
+		}
+
+		_, itemScope := itemContext(&item, nil, scope)
+		values, err := loopValues(item.Loop, itemScope.Get)
+
+		if err != nil {
+			fmt.Printf("ERROR: item %v has invalid loop: %v\n", item.Name, err)
+			item.State = CFGError
+			item.Reason = fmt.Sprintf("loop: %v", err)
+			items = append(items, item)
+			ok = false
+			continue
+		}
+
+		if len(values) == 0 {
+			fmt.Printf("WARNING: loop of item %v has no values, it won't run\n", item.Name)
+		}
+
+		names := []string{}
+
+		for i, val := range values {
+			label := fmt.Sprint(i)
+
+			if isScalar(val) {
+				label = optionString(val)
+			}
+
+			instance := item
+			instance.Name = fmt.Sprintf("%v[%v]", item.Name, label)
+			instance.Loop = nil
+			instance.LoopItem = val
+			instance.IsLoop = true
+
+			items = append(items, instance)
+			names = append(names, instance.Name)
+		}
+
+		expanded[item.Name] = names
+	}
+
+	replacePreReqs(items, expanded)
+	cfg.Items = items
+
+	return ok
+}
+
+//loopValues - Returns the values to loop over. loop is either a list or a string with variables in it
+//(e.g. ${USERS}) that expands to a json list or a comma separated list.
+func loopValues(loop interface{}, lookup func(string) (string, bool)) ([]interface{}, error) {
+	switch v := loop.(type) {
+	case []interface{}:
+		values, _, err := expandValue(v, lookup)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return values.([]interface{}), nil
+	case string:
+		text, _, err := expandVars(v, lookup)
+
+		if err != nil {
+			return nil, err
+		}
+
+		text = strings.TrimSpace(text)
+
+		if strings.HasPrefix(text, "[") {
+			var values []interface{}
+
+			if err := json.Unmarshal([]byte(text), &values); err != nil {
+				return nil, fmt.Errorf("%q is not a valid json list: %v", text, err)
+			}
+
+			return values, nil
+		}
+
+		var values []interface{}
+
+		for _, val := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+			if val = strings.TrimSpace(val); val != "" {
+				values = append(values, val)
+			}
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("must be a list or a variable holding one")
+	}
+}
+
+//itemLookup - Looks up variables for item, adding item for the value of its loop
+func itemLookup(item *ConfigItem, scope *VarScope) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if item.IsLoop && name == "item" {
+			return optionString(item.LoopItem), true
+		}
+
+		return scope.Get(name)
+	}
+}
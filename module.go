@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//moduleInfo - A config folder used as a module and what its items run with
+type moduleInfo struct {
+	Name      string         //Name of the module item, the module's items are named Name/item
+	Path      string         //Folder of the module
+	Resources []ResourceInfo //Resources of the module
+	Scope     *VarScope      //Parameters and facts of the module, falls back to the scope of the config using it
+}
+
+//mergeIncludes - Adds the items and handlers of the files config includes. Paths are relative to the
+//file at path and can be globs. including is the chain of files that led to path so loops can be reported.
+func mergeIncludes(config *ConfigInfo, path string, including []string) error {
+	including = append(append([]string{}, including...), path)
+
+	for _, inc := range config.Include {
+		pattern := inc
+
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		files, err := filepath.Glob(pattern)
+
+		if err == nil && len(files) == 0 {
+			err = fmt.Errorf("no files match %v", inc)
+		}
+
+		if err != nil {
+			fmt.Printf("Failed to include %v from %v\n", inc, path)
+			return err
+		}
+
+		for _, file := range files {
+			for _, f := range including {
+				if f == file {
+					return fmt.Errorf("include loop detected: %v", strings.Join(append(including, file), " -> "))
+				}
+			}
+
+			included, err := readConfig(file, including)
+
+			if err != nil {
+				fmt.Printf("Failed to include %v from %v\n", file, path)
+				return err
+			}
+
+			config.Items = append(config.Items, included.Items...)
+			config.Handlers = append(config.Handlers, included.Handlers...)
+		}
+	}
+
+	return nil
+}
+
+//expandModules - Replaces every module item with the items and handlers of the module, named
+//<module item>/<item>. Items that had the module item as a prerequisite wait for all of the module's items.
+//using is the chain of module folders that led here so loops can be reported.
+func expandModules(ctx context.Context, cfg *ConfigInfo, scope *VarScope, naming string, using []string) error {
+	var items []ConfigItem
+	expanded := make(map[string][]string)
+
+	for _, item := range cfg.Items {
+		if item.Module == "" {
+			items = append(items, item)
+			continue
+		}
+
+		modItems, handlers, err := loadModule(ctx, item, scope, naming, using)
+
+		if err != nil {
+			return err
+		}
+
+		names := []string{}
+
+		for _, m := range modItems {
+			names = append(names, m.Name)
+		}
+
+		items = append(items, modItems...)
+		cfg.Handlers = append(cfg.Handlers, handlers...)
+		expanded[item.Name] = names
+	}
+
+	replacePreReqs(items, expanded)
+	cfg.Items = items
+
+	return nil
+}
+
+//loadModule - Loads the module item points at, runs its runtimes and gatherers in a scope of its own with
+//the item's options as properties, and returns its items and handlers ready to add to the config using it.
+//Returns no items if the item's or the module's condition is false.
+func loadModule(ctx context.Context, item ConfigItem, scope *VarScope, naming string, using []string) ([]ConfigItem, []ConfigItem, error) {
+	path := item.Module
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(item.Source), path)
+	}
+
+	for _, u := range using {
+		if u == path {
+			return nil, nil, fmt.Errorf("module loop detected: %v", strings.Join(append(using, path), " -> "))
+		}
+	}
+
+	if item.Resource != "" || item.Loop != nil || len(item.Notify) > 0 || item.Register != "" {
+		return nil, nil, fmt.Errorf("module item %v can't have a resource, loop, notify or register", item.Name)
+	}
+
+	if item.Condition != "" {
+		ok, err := evalCondition(item.Condition, scope.Get)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("module item %v has invalid condition %q: %v", item.Name, item.Condition, err)
+		}
+
+		if !ok {
+			fmt.Printf("Skipping module %v as condition %v is false\n", item.Name, item.Condition)
+			return nil, nil, nil
+		}
+	}
+
+	fmt.Printf("Loading module %v from %v\n", item.Name, path)
+
+	params, _, err := expandOptions(item.Options, scope.Get)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("module item %v: %v", item.Name, err)
+	}
+
+	child := newChildScope(scope)
+
+	for key, val := range params {
+		fmt.Printf("Setting %v = %v\n", key, optionString(val))
+		child.Set(LayerProperty, key, optionString(val))
+	}
+
+	if err := loadRuntimes(path, child); err != nil {
+		return nil, nil, err
+	}
+
+	if err := loadGatherers(ctx, path, child, naming); err != nil {
+		return nil, nil, err
+	}
+
+	resources, err := loadResources(path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := loadConfig(path + "/config.yaml")
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Condition != "" {
+		ok, err := evalCondition(cfg.Condition, child.Get)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("module %v has invalid condition %q: %v", item.Name, cfg.Condition, err)
+		}
+
+		if !ok {
+			fmt.Printf("Module %v is not applicable as condition %v is false\n", item.Name, cfg.Condition)
+			return nil, nil, nil
+		}
+	}
+
+	if err := expandModules(ctx, &cfg, child, naming, append(append([]string{}, using...), path)); err != nil {
+		return nil, nil, err
+	}
+
+	info := &moduleInfo{Name: item.Name, Path: path, Resources: resources, Scope: child}
+
+	//The module's items also wait for the module item's prerequisites.
+	for i := range cfg.Items {
+		cfg.Items[i].PreReq = append(prefixNames(item.Name, cfg.Items[i].PreReq), item.PreReq...)
+	}
+
+	for i := range cfg.Handlers {
+		cfg.Handlers[i].PreReq = prefixNames(item.Name, cfg.Handlers[i].PreReq)
+	}
+
+	return namespaceItems(info, cfg.Items), namespaceItems(info, cfg.Handlers), nil
+}
+
+//namespaceItems - Prefixes the names of a module's items, and the names they refer to, with the module's name
+func namespaceItems(info *moduleInfo, items []ConfigItem) []ConfigItem {
+	for i := range items {
+		items[i].Name = info.Name + "/" + items[i].Name
+		items[i].Notify = prefixNames(info.Name, items[i].Notify)
+
+		//Items of modules inside this one already have their own.
+		if items[i].module == nil {
+			items[i].module = info
+		}
+	}
+
+	return items
+}
+
+func prefixNames(prefix string, names []string) []string {
+	var prefixed []string
+
+	for _, name := range names {
+		prefixed = append(prefixed, prefix+"/"+name)
+	}
+
+	return prefixed
+}
+
+//replacePreReqs - Replaces prerequisites that are keys of expanded with the names of the items they became
+func replacePreReqs(items []ConfigItem, expanded map[string][]string) {
+	for i := range items {
+		var prereqs []string
+
+		for _, p := range items[i].PreReq {
+			if names, found := expanded[p]; found {
+				prereqs = append(prereqs, names...)
+			} else {
+				prereqs = append(prereqs, p)
+			}
+		}
+
+		items[i].PreReq = prereqs
+	}
+}
+
+//nameCollisions - Describes every name used by more than one item, with the files they came from
+func nameCollisions(kind string, items []ConfigItem) []string {
+	sources := make(map[string][]string)
+
+	for _, item := range items {
+		sources[item.Name] = append(sources[item.Name], item.Source)
+	}
+
+	var collisions []string
+
+	for name, files := range sources {
+		if len(files) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%v %v is defined more than once, in %v", kind, name, strings.Join(files, ", ")))
+		}
+	}
+
+	sort.Strings(collisions)
+
+	return collisions
+}
+
+//itemContext - Returns the resources and scope item runs with, which are the module's for items from a module
+func itemContext(item *ConfigItem, resources []ResourceInfo, scope *VarScope) ([]ResourceInfo, *VarScope) {
+	if item.module == nil {
+		return resources, scope
+	}
+
+	return item.module.Resources, item.module.Scope
+}
+
+//moduleName - Returns the name of the module item came from, empty if it isn't from a module
+func moduleName(item ConfigItem) string {
+	if item.module == nil {
+		return ""
+	}
+
+	return item.module.Name
+}
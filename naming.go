@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+//How variables are named in a script's environment
+const (
+	NamingBoth     = "both"     //Bare names plus the prefixed names (default)
+	NamingPrefixed = "prefixed" //Only prefixed names, so nothing can shadow the real environment
+	NamingBare     = "bare"     //Only bare names, how spanr used to work
+)
+
+//Prefixes used for the namespaced names
+const (
+	OptionPrefix   = "SPANR_OPT_"
+	PropertyPrefix = "SPANR_CFG_"
+	FactPrefix     = "SPANR_FACT_"
+)
+
+//Variables scripts and the system rely on, options shouldn't replace them
+var criticalVars = []string{
+	"PATH", "HOME", "USER", "SHELL", "PWD", "TMPDIR", "TEMP", "TMP", "LD_LIBRARY_PATH", "LD_PRELOAD",
+	"SYSTEMROOT", "WINDIR", "COMSPEC", "PATHEXT", "USERPROFILE", "SPANR_PROTOCOL_FD",
+}
+
+func validateNaming(naming string) error {
+	switch naming {
+	case "", NamingBoth, NamingPrefixed, NamingBare:
+		return nil
+	default:
+		return fmt.Errorf("unknown naming %q, must be %v, %v or %v", naming, NamingBoth, NamingPrefixed, NamingBare)
+	}
+}
+
+//resolveNaming - Returns the naming a resource or gatherer set, or the default for the run if it didn't
+func resolveNaming(naming string, def string) string {
+	if naming != "" {
+		return naming
+	}
+
+	if def != "" {
+		return def
+	}
+
+	return NamingBoth
+}
+
+//isCriticalVar - Checks if name is one of criticalVars. Windows names aren't case sensitive so neither is this.
+func isCriticalVar(name string) bool {
+	for _, v := range criticalVars {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//addNamed - Adds key to env as KEY=VALUE under its bare and/or prefixed name depending on naming.
+//env is keyed by envKey of the name.
+func addNamed(env map[string]string, naming string, prefix string, key string, value string) {
+	if naming != NamingPrefixed || prefix == "" {
+		env[envKey(key)] = key + "=" + value
+	}
+
+	if naming != NamingBare && prefix != "" {
+		env[envKey(prefix+key)] = prefix + key + "=" + value
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//normalizeValue - Converts the map[interface{}]interface{} yaml produces for nested maps into
+//map[string]interface{} so option values can be turned into json.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+
+		for key, val := range v {
+			m[fmt.Sprint(key)] = normalizeValue(val)
+		}
+
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+
+		for key, val := range v {
+			m[key] = normalizeValue(val)
+		}
+
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+
+		for i, val := range v {
+			l[i] = normalizeValue(val)
+		}
+
+		return l
+	default:
+		return value
+	}
+}
+
+//normalizeOptions - Runs normalizeValue over every option
+func normalizeOptions(options map[string]interface{}) map[string]interface{} {
+	if options == nil {
+		return nil
+	}
+
+	return normalizeValue(options).(map[string]interface{})
+}
+
+//isScalar - Returns true if value isn't a list or map
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	default:
+		return true
+	}
+}
+
+//optionString - Returns value the way it is put in the environment. Lists and maps become json.
+func optionString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}, map[string]interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+//expandValue - Expands variable references in every string inside value. Anything that isn't a string is left as is.
+func expandValue(value interface{}, lookup func(string) (string, bool)) (interface{}, []string, error) {
+	switch v := value.(type) {
+	case string:
+		text, missing, err := expandVars(v, lookup)
+		return text, missing, err
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		var unresolved []string
+
+		for i, val := range v {
+			expanded, missing, err := expandValue(val, lookup)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("[%v]: %v", i, err)
+			}
+
+			l[i] = expanded
+			unresolved = append(unresolved, missing...)
+		}
+
+		return l, unresolved, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		var unresolved []string
+
+		for _, key := range sortedKeys(v) {
+			expanded, missing, err := expandValue(v[key], lookup)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("[%v]: %v", key, err)
+			}
+
+			m[key] = expanded
+			unresolved = append(unresolved, missing...)
+		}
+
+		return m, unresolved, nil
+	default:
+		return value, nil, nil
+	}
+}
+
+//valueHasVarRefs - Returns true if any string inside value has a variable reference
+func valueHasVarRefs(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return hasVarRefs(v)
+	case []interface{}:
+		for _, val := range v {
+			if valueHasVarRefs(val) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, val := range v {
+			if valueHasVarRefs(val) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//exportOptions - Turns options into environment variables. Scalars are set as they are, lists and maps are
+//set as json and also flattened into NAME_0, NAME_1 for lists and NAME_key for maps.
+func exportOptions(options map[string]interface{}) map[string]string {
+	env := make(map[string]string)
+
+	for name, val := range options {
+		env[name] = optionString(val)
+		flattenOption(env, name, val)
+	}
+
+	return env
+}
+
+func flattenOption(env map[string]string, name string, value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, val := range v {
+			key := fmt.Sprintf("%v_%v", name, i)
+			env[key] = optionString(val)
+			flattenOption(env, key, val)
+		}
+	case map[string]interface{}:
+		for k, val := range v {
+			key := name + "_" + envName(k)
+			env[key] = optionString(val)
+			flattenOption(env, key, val)
+		}
+	}
+}
+
+//envName - Replaces anything that can't be in a shell variable name with _ (e.g. net.ipv4.ip_forward)
+func envName(key string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(key); i++ {
+		//Keys always follow NAME_ so digits are fine anywhere.
+		if isNameChar(key[i], false) {
+			b.WriteByte(key[i])
+		} else {
+			b.WriteByte('_')
+		}
+	}
+
+	return b.String()
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+)
+
+//Failure policy modes
+const (
+	FailStop     = "stop"     //Stop starting new items after the first failure
+	FailContinue = "continue" //Keep running items that don't depend on a failed one
+)
+
+//FailurePolicy - Holds what happens to a run when config items fail
+type FailurePolicy struct {
+	Mode        string //stop or continue, defaults to stop
+	MaxFailures int    //If set, keep going until this many items have failed
+}
+
+//UnmarshalYAML - Accepts "stop", "continue" or "max_failures: N"
+func (f *FailurePolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mode string
+
+	if err := unmarshal(&mode); err == nil {
+		if mode != FailStop && mode != FailContinue {
+			return fmt.Errorf("on_failure must be stop, continue or max_failures, not %q", mode)
+		}
+
+		*f = FailurePolicy{Mode: mode}
+		return nil
+	}
+
+	var limit struct {
+		MaxFailures int `yaml:"max_failures"`
+	}
+
+	if err := unmarshal(&limit); err != nil {
+		return err
+	}
+
+	if limit.MaxFailures < 1 {
+		return fmt.Errorf("max_failures must be at least 1")
+	}
+
+	*f = FailurePolicy{MaxFailures: limit.MaxFailures}
+
+	return nil
+}
+
+//MarshalYAML - Writes the policy back out in the same form it is read in
+func (f FailurePolicy) MarshalYAML() (interface{}, error) {
+	if f.MaxFailures > 0 {
+		return map[string]int{"max_failures": f.MaxFailures}, nil
+	}
+
+	if f.Mode == "" {
+		return FailStop, nil
+	}
+
+	return f.Mode, nil
+}
+
+//Stops - Returns true if a run with this many failures should stop starting new items
+func (f FailurePolicy) Stops(failures int) bool {
+	if failures == 0 {
+		return false
+	}
+
+	if f.MaxFailures > 0 {
+		return failures >= f.MaxFailures
+	}
+
+	return f.Mode != FailContinue
+}
+
+//failed - Returns true if item failed in a way that counts against the run
+func failed(item ConfigItem) bool {
+	return item.State == CFGError && !item.IgnoreErrors
+}
+
+//runState - Works out the overall state of a run that wasn't stopped by a reboot or interrupt from its items.
+//Outside of test mode an item left not configured failed too.
+func runState(items []ConfigItem) int {
+	for _, item := range items {
+		if failed(item) || (item.State == CFGNotConfigured && !item.IgnoreErrors) {
+			return CFGError
+		}
+	}
+
+	return CFGConfigured
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+//setProcessGroup - Starts cmd in its own process group so anything it spawns can be killed with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+//killProcessGroup - Kills cmd and every process in its process group
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+//signalProcessGroup - Passes sig on to cmd and every process in its process group
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	s, ok := sig.(syscall.Signal)
+
+	if !ok {
+		s = syscall.SIGTERM
+	}
+
+	return syscall.Kill(-cmd.Process.Pid, s)
+}
+
+//envKey - Returns the key an environment variable is stored under, which is its name as names are case sensitive
+func envKey(name string) string {
+	return name
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//setProcessGroup - Starts cmd in its own process group so anything it spawns can be killed with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+//killProcessGroup - Kills cmd and every process it started
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	//Windows has no process group kill, taskkill /T walks the process tree instead.
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+
+	if err != nil {
+		return cmd.Process.Kill()
+	}
+
+	return nil
+}
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+//signalProcessGroup - Sends a ctrl+break to cmd's process group, the closest windows has to passing on sig
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	const ctrlBreakEvent = 1
+
+	r, _, err := generateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(cmd.Process.Pid))
+
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+//envKey - Returns the key an environment variable is stored under. Names aren't case sensitive on windows so
+//Path and PATH share a key.
+func envKey(name string) string {
+	return strings.ToUpper(name)
+}
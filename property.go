@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//Property types
+const (
+	PropString = "string"
+	PropInt    = "int"
+	PropBool   = "bool"
+	PropList   = "list"
+	PropPath   = "path"
+	PropMap    = "map"
+)
+
+//PropertyInfo - Holds the schema of a property a resource accepts
+type PropertyInfo struct {
+	Mandatory   bool        //Config item must set the property (unless it has a default)
+	Type        string      //One of string, int, bool, list, map or path. Defaults to string.
+	Default     interface{} //Value passed to the resource when the config item doesn't set it. Can be a list or map like options.
+	Allowed     []string    //If set the value must be one of these
+	Pattern     string      //If set the value must match this regular expression
+	Description string      //Description of property
+}
+
+//UnmarshalYAML - Accepts either the short "name: bool" form where bool is mandatory, or the full schema
+func (p *PropertyInfo) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mandatory bool
+
+	if err := unmarshal(&mandatory); err == nil {
+		*p = PropertyInfo{Mandatory: mandatory}
+		return nil
+	}
+
+	//Alias stops this method being called again for the full form.
+	type propertyInfo PropertyInfo
+	var full propertyInfo
+
+	if err := unmarshal(&full); err != nil {
+		return err
+	}
+
+	*p = PropertyInfo(full)
+	p.Default = normalizeValue(p.Default)
+
+	return nil
+}
+
+//Required - Returns true if a config item has to set the property
+func (p PropertyInfo) Required() bool {
+	return p.Mandatory && !p.HasDefault()
+}
+
+//HasDefault - Returns true if the property has a default, an empty string counts as no default
+func (p PropertyInfo) HasDefault() bool {
+	return p.Default != nil && p.Default != ""
+}
+
+//Validate - Checks value against the property's type, allowed values and pattern.
+//Lists are only accepted by list properties (each element is checked) and maps by map properties.
+func (p PropertyInfo) Validate(value interface{}) error {
+	switch v := value.(type) {
+	case []interface{}:
+		if p.Type != PropList {
+			return fmt.Errorf("got a list but property is %v", p.typeName())
+		}
+
+		for i, val := range v {
+			if !isScalar(val) {
+				return fmt.Errorf("element %v is not a single value", i)
+			}
+
+			if err := p.validateText(optionString(val)); err != nil {
+				return fmt.Errorf("element %v: %v", i, err)
+			}
+		}
+
+		return nil
+	case map[string]interface{}:
+		if p.Type != PropMap {
+			return fmt.Errorf("got a map but property is %v", p.typeName())
+		}
+
+		return nil
+	}
+
+	if p.Type == PropMap {
+		return fmt.Errorf("%q is not a map", optionString(value))
+	}
+
+	return p.validateText(optionString(value))
+}
+
+func (p PropertyInfo) validateText(value string) error {
+	switch p.Type {
+	case "", PropString, PropList:
+	case PropInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%q is not an int", value)
+		}
+	case PropBool:
+		if _, err := parseBool(value); err != nil {
+			return err
+		}
+	case PropPath:
+		if value == "" {
+			return fmt.Errorf("path can't be empty")
+		}
+	default:
+		return fmt.Errorf("unknown property type %v", p.Type)
+	}
+
+	if len(p.Allowed) > 0 {
+		found := false
+
+		for _, a := range p.Allowed {
+			if a == value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%q is not one of %v", value, strings.Join(p.Allowed, ", "))
+		}
+	}
+
+	if p.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+
+		if err != nil {
+			return fmt.Errorf("pattern %q is not valid: %v", p.Pattern, err)
+		}
+
+		if !re.MatchString(value) {
+			return fmt.Errorf("%q doesn't match pattern %v", value, p.Pattern)
+		}
+	}
+
+	return nil
+}
+
+func (p PropertyInfo) typeName() string {
+	if p.Type == "" {
+		return PropString
+	}
+
+	return p.Type
+}
+
+//String - Describes the property on one line for spanr ls
+func (p PropertyInfo) String() string {
+	text := p.typeName()
+
+	if p.Mandatory {
+		text += ", mandatory"
+	}
+
+	if def, ok := p.Default.(string); ok && def != "" {
+		text += fmt.Sprintf(", default %q", def)
+	} else if p.HasDefault() {
+		text += fmt.Sprintf(", default %v", optionString(p.Default))
+	}
+
+	if len(p.Allowed) > 0 {
+		text += fmt.Sprintf(", one of [%v]", strings.Join(p.Allowed, ", "))
+	}
+
+	if p.Pattern != "" {
+		text += fmt.Sprintf(", matches %v", p.Pattern)
+	}
+
+	if p.Description != "" {
+		text += " - " + p.Description
+	}
+
+	return text
+}
+
+//parseBool - Parses the boolean forms yaml accepts as well as the ones strconv does
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+
+	if err != nil {
+		return false, fmt.Errorf("%q is not a bool", value)
+	}
+
+	return b, nil
+}
+
+//resourceOptions - Returns item's options with the resource's defaults filled in for anything the item didn't set
+func resourceOptions(item ConfigItem, resource ResourceInfo) map[string]interface{} {
+	options := make(map[string]interface{})
+
+	for name, prop := range resource.Properties {
+		if prop.HasDefault() {
+			options[name] = prop.Default
+		}
+	}
+
+	for name, val := range item.Options {
+		options[name] = val
+	}
+
+	return options
+}
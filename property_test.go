@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPropertyDefaults(t *testing.T) {
+	data := `
+short: true
+text:
+  default: "present"
+list:
+  type: list
+  default: [vim, git]
+dict:
+  type: map
+  mandatory: yes
+  default: {port: 80}
+empty:
+  mandatory: yes
+  default: ""
+`
+	var props map[string]PropertyInfo
+
+	if err := yaml.Unmarshal([]byte(data), &props); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		def      interface{}
+		required bool
+	}{
+		{"short", nil, true},
+		{"text", "present", false},
+		{"list", []interface{}{"vim", "git"}, false},
+		{"dict", map[string]interface{}{"port": 80}, false},
+		{"empty", "", true},
+	}
+
+	for _, test := range tests {
+		prop := props[test.name]
+
+		if !reflect.DeepEqual(prop.Default, test.def) {
+			t.Errorf("%v default = %#v, want %#v", test.name, prop.Default, test.def)
+		}
+
+		if prop.Required() != test.required {
+			t.Errorf("%v required = %v, want %v", test.name, prop.Required(), test.required)
+		}
+
+		if prop.HasDefault() {
+			if err := prop.Validate(prop.Default); err != nil {
+				t.Errorf("%v default doesn't validate: %v", test.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//Script output protocols
+const (
+	ProtocolMarkers  = "markers"  //##CONFIGURED##, ##SPANR[name=value]## etc anywhere in stdout (the default)
+	ProtocolJSON     = "json"     //One JSON object per line, e.g. {"state":"configured"}
+	ProtocolExitCode = "exitcode" //State comes from the exit code, markers can still set variables and messages
+)
+
+//Exit codes used by the exitcode protocol when a resource doesn't list its own
+var defaultExitCodes = map[int]string{
+	0:    "configured",
+	1:    "notconfigured",
+	3010: "reboot",
+}
+
+//Longest protocol line spanr will read, longer lines are split
+const maxProtocolLine = 1024 * 1024
+
+var progressMarker = regexp.MustCompile(`##SPANRPROGRESS\[([0-9]+)\|(.*?)\]##`)
+
+//scriptOutput - Holds what a script reported back to spanr
+type scriptOutput struct {
+	State    int               //State reported, CFGError if none was
+	Vars     map[string]string //Variables to set for later scripts
+	Messages []string          //Messages to print
+	Stdout   string            //The end of what the script wrote to stdout
+	Stderr   string            //The end of what the script wrote to stderr
+}
+
+//outputHandler - Called while a script is running as it reports things. Any of the functions can be nil.
+type outputHandler struct {
+	Var      func(key string, value string)
+	Message  func(msg string)
+	Progress func(percent int, text string)
+}
+
+func (h outputHandler) setVar(vars map[string]string, key string, value string) {
+	vars[key] = value
+
+	if h.Var != nil {
+		h.Var(key, value)
+	}
+}
+
+func (h outputHandler) message(messages *[]string, msg string) {
+	*messages = append(*messages, msg)
+
+	if h.Message != nil {
+		h.Message(msg)
+	}
+}
+
+func (h outputHandler) progress(percent int, text string) {
+	if h.Progress != nil {
+		h.Progress(percent, text)
+	}
+}
+
+//outputParser - Reads a script's output a line at a time
+type outputParser interface {
+	Line(line string)
+	Result() scriptOutput
+}
+
+//validateProtocol - Checks protocol is one spanr understands
+func validateProtocol(protocol string) error {
+	switch protocol {
+	case "", ProtocolMarkers, ProtocolJSON, ProtocolExitCode:
+		return nil
+	default:
+		return fmt.Errorf("unknown protocol %q, must be %v, %v or %v", protocol, ProtocolMarkers, ProtocolJSON, ProtocolExitCode)
+	}
+}
+
+//validateResourceProtocol - Checks a resource's protocol and, for the exitcode protocol, its exit code states
+func validateResourceProtocol(resource ResourceInfo) error {
+	if err := validateProtocol(resource.Protocol); err != nil {
+		return err
+	}
+
+	if len(resource.ExitCodes) > 0 && resource.Protocol != ProtocolExitCode {
+		return fmt.Errorf("exitcodes are only used with protocol %v", ProtocolExitCode)
+	}
+
+	for code, name := range resource.ExitCodes {
+		if _, ok := stateFromName(name); !ok {
+			return fmt.Errorf("exit code %v has unknown state %q", code, name)
+		}
+	}
+
+	return nil
+}
+
+//stateFromName - Converts the state names used by the json and exitcode protocols into a state
+func stateFromName(name string) (int, bool) {
+	switch strings.ToLower(name) {
+	case "configured":
+		return CFGConfigured, true
+	case "notconfigured", "not_configured":
+		return CFGNotConfigured, true
+	case "reboot":
+		return CFGRebootRequired, true
+	case "fail":
+		return CFGError, true
+	default:
+		return CFGError, false
+	}
+}
+
+//exitCodeState - Returns the state an exit code maps to for a resource using the exitcode protocol.
+//Codes that aren't mapped are a failure and return false.
+func exitCodeState(resource ResourceInfo, code int) (int, bool) {
+	codes := resource.ExitCodes
+
+	if len(codes) == 0 {
+		codes = defaultExitCodes
+	}
+
+	name, ok := codes[code]
+
+	if !ok {
+		return CFGError, false
+	}
+
+	state, _ := stateFromName(name)
+
+	return state, true
+}
+
+func newOutputParser(protocol string, handler outputHandler) outputParser {
+	if protocol == ProtocolJSON {
+		return &jsonParser{handler: handler, output: scriptOutput{State: CFGError, Vars: make(map[string]string)}}
+	}
+
+	return &markerParser{handler: handler, vars: make(map[string]string)}
+}
+
+//markerParser - Parses the original ##MARKER## protocol
+type markerParser struct {
+	handler                                 outputHandler
+	fail, configured, reboot, notConfigured bool
+	vars                                    map[string]string
+	messages                                []string
+}
+
+func (p *markerParser) Line(line string) {
+	p.fail = p.fail || strings.Contains(line, "##FAIL##")
+	p.configured = p.configured || strings.Contains(line, "##CONFIGURED##")
+	p.reboot = p.reboot || strings.Contains(line, "##REBOOT##")
+	p.notConfigured = p.notConfigured || strings.Contains(line, "##NOTCONFIGURED##")
+
+	for key, val := range getVarsFromStd(line) {
+		p.handler.setVar(p.vars, key, val)
+	}
+
+	for _, msg := range getMessagesFromStd(line) {
+		p.handler.message(&p.messages, msg)
+	}
+
+	for _, match := range progressMarker.FindAllStringSubmatch(line, -1) {
+		percent, _ := strconv.Atoi(match[1])
+		p.handler.progress(percent, match[2])
+	}
+}
+
+//Result - Works out the state from the markers seen. ##FAIL## wins over ##CONFIGURED##, then ##REBOOT##, then ##NOTCONFIGURED##.
+func (p *markerParser) Result() scriptOutput {
+	state := CFGError
+
+	switch {
+	case p.fail:
+		state = CFGError
+	case p.configured:
+		state = CFGConfigured
+	case p.reboot:
+		state = CFGRebootRequired
+	case p.notConfigured:
+		state = CFGNotConfigured
+	}
+
+	return scriptOutput{State: state, Vars: p.vars, Messages: p.messages}
+}
+
+//jsonLine - A line of the json protocol. Any of the fields can be set.
+type jsonLine struct {
+	State    *string                `json:"state"` //configured, notconfigured, reboot or fail
+	Var      map[string]interface{} `json:"var"`   //Variables to set
+	Msg      *string                `json:"msg"`   //Message to print
+	Progress *struct {
+		Percent int    `json:"percent"`
+		Text    string `json:"text"`
+	} `json:"progress"` //How far through the script is
+}
+
+//jsonParser - Parses the json line protocol. Lines that aren't json objects are treated as log output.
+type jsonParser struct {
+	handler outputHandler
+	output  scriptOutput
+}
+
+func (p *jsonParser) Line(line string) {
+	line = strings.TrimSpace(line)
+
+	if !strings.HasPrefix(line, "{") {
+		return
+	}
+
+	var msg jsonLine
+
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		fmt.Printf("WARNING: ignoring bad protocol line %q: %v\n", line, err)
+		return
+	}
+
+	if msg.State != nil {
+		state, ok := stateFromName(*msg.State)
+
+		if !ok {
+			fmt.Printf("WARNING: unknown state %q treated as fail\n", *msg.State)
+		}
+
+		p.output.State = state
+	}
+
+	for key, val := range msg.Var {
+		if text, ok := val.(string); ok {
+			p.handler.setVar(p.output.Vars, key, text)
+			continue
+		}
+
+		data, _ := json.Marshal(val)
+		p.handler.setVar(p.output.Vars, key, string(data))
+	}
+
+	if msg.Msg != nil {
+		p.handler.message(&p.output.Messages, *msg.Msg)
+	}
+
+	if msg.Progress != nil {
+		p.handler.progress(msg.Progress.Percent, msg.Progress.Text)
+	}
+}
+
+func (p *jsonParser) Result() scriptOutput {
+	return p.output
+}
+
+//runWithProtocol - Runs cmd and parses what it reports with protocol as it runs, calling handler as things
+//are reported. Normally the protocol is read from stdout, with useFD it is read from file descriptor 3 instead
+//(SPANR_PROTOCOL_FD is set to tell the script). Stdout and stderr are written to log, only the end of each
+//is kept in the output.
+func runWithProtocol(ctx context.Context, cmd *exec.Cmd, protocol string, useFD bool, log *scriptLog, handler outputHandler) (scriptOutput, error) {
+	stdout := newTailBuffer(maxCapturedOutput)
+	stderr := newTailBuffer(maxCapturedOutput)
+	parser := newOutputParser(protocol, handler)
+	lines := newLineWriter(maxProtocolLine, parser.Line)
+
+	stdoutWriters := []io.Writer{stdout, log.Stream("stdout")}
+
+	if !useFD {
+		stdoutWriters = append(stdoutWriters, lines)
+	}
+
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderr, log.Stream("stderr"))
+
+	if !useFD {
+		err := runCommand(ctx, cmd)
+		lines.Flush()
+
+		return withStreams(parser.Result(), stdout, stderr), err
+	}
+
+	reader, writer, err := os.Pipe()
+
+	if err != nil {
+		return scriptOutput{State: CFGError}, err
+	}
+
+	cmd.ExtraFiles = []*os.File{writer}
+	cmd.Env = append(cmd.Env, "SPANR_PROTOCOL_FD=3")
+
+	done := make(chan struct{})
+
+	go func() {
+		io.Copy(lines, reader)
+		reader.Close()
+		close(done)
+	}()
+
+	err = runCommand(ctx, cmd)
+
+	//Once our copy of the write end is closed the reader sees EOF when the script's copy goes too.
+	writer.Close()
+	<-done
+	lines.Flush()
+
+	return withStreams(parser.Result(), stdout, stderr), err
+}
+
+func withStreams(output scriptOutput, stdout *tailBuffer, stderr *tailBuffer) scriptOutput {
+	output.Stdout = stdout.String()
+	output.Stderr = stderr.String()
+
+	return output
+}
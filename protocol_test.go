@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//recorder - Records what a parser passes to its handler
+type recorder struct {
+	vars     []string
+	messages []string
+	progress []string
+}
+
+func (r *recorder) handler() outputHandler {
+	return outputHandler{
+		Var:      func(key string, value string) { r.vars = append(r.vars, key+"="+value) },
+		Message:  func(msg string) { r.messages = append(r.messages, msg) },
+		Progress: func(percent int, text string) { r.progress = append(r.progress, fmt.Sprintf("%v|%v", percent, text)) },
+	}
+}
+
+func TestMarkerParser(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		state int
+	}{
+		{"nothing reported", []string{"just logging"}, CFGError},
+		{"configured", []string{"checking", "##CONFIGURED##"}, CFGConfigured},
+		{"not configured", []string{"##NOTCONFIGURED##"}, CFGNotConfigured},
+		{"reboot", []string{"##REBOOT##"}, CFGRebootRequired},
+		{"fail wins", []string{"##CONFIGURED##", "##FAIL##"}, CFGError},
+		{"configured wins over reboot", []string{"##REBOOT##", "##CONFIGURED##"}, CFGConfigured},
+		{"reboot wins over not configured", []string{"##NOTCONFIGURED##", "##REBOOT##"}, CFGRebootRequired},
+		{"marker inside a line", []string{"result: ##CONFIGURED## done"}, CFGConfigured},
+	}
+
+	for _, test := range tests {
+		p := newOutputParser(ProtocolMarkers, outputHandler{})
+
+		for _, line := range test.lines {
+			p.Line(line)
+		}
+
+		if got := p.Result().State; got != test.state {
+			t.Errorf("%v: state %v, want %v", test.name, printCFG(got), printCFG(test.state))
+		}
+	}
+}
+
+func TestMarkerParserReports(t *testing.T) {
+	r := &recorder{}
+	p := newOutputParser(ProtocolMarkers, r.handler())
+
+	p.Line("##SPANR[version=1.2]## ##SPANRMSG[installing]##")
+	p.Line("##SPANRPROGRESS[40|Installing packages]## ##SPANRPROGRESS[100|]##")
+	p.Line("##SPANR[version=1.3]## ##SPANR[args=a=b]##")
+
+	result := p.Result()
+
+	if want := map[string]string{"version": "1.3", "args": "a=b"}; !reflect.DeepEqual(result.Vars, want) {
+		t.Errorf("vars %v, want %v", result.Vars, want)
+	}
+
+	if want := []string{"installing"}; !reflect.DeepEqual(result.Messages, want) || !reflect.DeepEqual(r.messages, want) {
+		t.Errorf("messages %v and handled %v, want %v", result.Messages, r.messages, want)
+	}
+
+	//Vars on the same line are handled in any order.
+	sort.Strings(r.vars)
+
+	if want := []string{"args=a=b", "version=1.2", "version=1.3"}; !reflect.DeepEqual(r.vars, want) {
+		t.Errorf("handled vars %v, want %v", r.vars, want)
+	}
+
+	if want := []string{"40|Installing packages", "100|"}; !reflect.DeepEqual(r.progress, want) {
+		t.Errorf("progress %v, want %v", r.progress, want)
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		state int
+		vars  map[string]string
+		msgs  []string
+	}{
+		{name: "nothing reported", lines: []string{"log line", ""}, state: CFGError},
+		{name: "state", lines: []string{`{"state": "configured"}`}, state: CFGConfigured},
+		{name: "last state wins", lines: []string{`{"state": "notconfigured"}`, `  {"state":"reboot"}  `}, state: CFGRebootRequired},
+		{name: "unknown state fails", lines: []string{`{"state": "configured"}`, `{"state": "maybe"}`}, state: CFGError},
+		{name: "bad json ignored", lines: []string{`{"state": "configured"}`, `{"state": `}, state: CFGConfigured},
+		{name: "not an object ignored", lines: []string{`["state", "fail"]`, `{"state": "configured"}`}, state: CFGConfigured},
+		{
+			name:  "vars",
+			lines: []string{`{"var": {"name": "value", "list": [1, "a"], "map": {"k": true}}}`, `{"state": "configured"}`},
+			state: CFGConfigured,
+			vars:  map[string]string{"name": "value", "list": `[1,"a"]`, "map": `{"k":true}`},
+		},
+		{
+			name:  "everything on one line",
+			lines: []string{`{"state": "configured", "var": {"a": "b"}, "msg": "done"}`},
+			state: CFGConfigured,
+			vars:  map[string]string{"a": "b"},
+			msgs:  []string{"done"},
+		},
+	}
+
+	for _, test := range tests {
+		p := newOutputParser(ProtocolJSON, outputHandler{})
+
+		for _, line := range test.lines {
+			p.Line(line)
+		}
+
+		result := p.Result()
+
+		if result.State != test.state {
+			t.Errorf("%v: state %v, want %v", test.name, printCFG(result.State), printCFG(test.state))
+		}
+
+		vars := test.vars
+
+		if vars == nil {
+			vars = map[string]string{}
+		}
+
+		if !reflect.DeepEqual(result.Vars, vars) {
+			t.Errorf("%v: vars %v, want %v", test.name, result.Vars, vars)
+		}
+
+		if !reflect.DeepEqual(result.Messages, test.msgs) {
+			t.Errorf("%v: messages %v, want %v", test.name, result.Messages, test.msgs)
+		}
+	}
+}
+
+func TestJSONParserProgress(t *testing.T) {
+	r := &recorder{}
+	p := newOutputParser(ProtocolJSON, r.handler())
+
+	p.Line(`{"progress": {"percent": 40, "text": "Installing packages"}}`)
+	p.Line(`##SPANRPROGRESS[50|markers aren't read]##`)
+
+	if want := []string{"40|Installing packages"}; !reflect.DeepEqual(r.progress, want) {
+		t.Errorf("progress %v, want %v", r.progress, want)
+	}
+}
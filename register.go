@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+//Prefix of the variables registered items are stored under
+const registerPrefix = "items."
+
+//Matches a use of a registered item in a condition or option, e.g. ${items.java.vars.version}
+var registerRef = regexp.MustCompile(`\bitems\.([A-Za-z_][A-Za-z0-9_]*)\.`)
+
+//stateName - Returns the short name of state used for registered items, e.g. ${items.web.state} == "changed"
+func stateName(state int) string {
+	switch state {
+	case CFGConfigured:
+		return "configured"
+	case CFGRebootRequired:
+		return "reboot"
+	case CFGNotConfigured:
+		return "notconfigured"
+	case CFGError:
+		return "error"
+	case CFGSkipOnDep:
+		return "skipped"
+	case CFGInterrupted:
+		return "interrupted"
+	case CFGNotApplicable:
+		return "notapplicable"
+	case CFGChanged:
+		return "changed"
+	default:
+		return "notrun"
+	}
+}
+
+//registerItem - Stores what item did in scope under items.<register>. so later items can use it in options
+//and conditions: state, changed, reason, stdout (of the last script run), messages and vars as json, and
+//each var as vars.<name>.
+func registerItem(scope *VarScope, item *ConfigItem) {
+	values := map[string]string{
+		"state":   stateName(item.State),
+		"changed": "false",
+		"reason":  item.Reason,
+	}
+
+	if item.State == CFGChanged {
+		values["changed"] = "true"
+	}
+
+	if len(item.Attempts) > 0 {
+		values["stdout"] = strings.TrimRight(item.Attempts[len(item.Attempts)-1].Stdout, "\n")
+	} else {
+		values["stdout"] = ""
+	}
+
+	messages, _ := json.Marshal(item.Messages)
+	values["messages"] = string(messages)
+
+	vars := item.Vars
+
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	data, _ := json.Marshal(vars)
+	values["vars"] = string(data)
+
+	for key, val := range vars {
+		values["vars."+key] = val
+	}
+
+	scope.Register(item.Register, values)
+}
+
+//registerDeps - Makes every item whose condition or options use items.<name> run after the item in items
+//that registers name in its scope, or the scope it falls back to. Unlike a prerequisite the item still runs
+//when the registering item fails, so it can act on ${items.name.state}.
+func registerDeps(items []ConfigItem, scope *VarScope) {
+	type scopeName struct {
+		Scope *VarScope
+		Name  string
+	}
+
+	registered := make(map[scopeName]string)
+
+	for i := range items {
+		if items[i].Register != "" {
+			_, itemScope := itemContext(&items[i], nil, scope)
+			registered[scopeName{itemScope, items[i].Register}] = items[i].Name
+		}
+	}
+
+	for i := range items {
+		_, itemScope := itemContext(&items[i], nil, scope)
+		deps := items[i].dependencies()
+
+		for _, name := range registerRefs(items[i]) {
+			for s := itemScope; s != nil; s = s.parent {
+				dep, ok := registered[scopeName{s, name}]
+
+				if !ok {
+					continue
+				}
+
+				if dep != items[i].Name && !containsName(deps, dep) {
+					items[i].After = append(items[i].After, dep)
+					deps = append(deps, dep)
+				}
+
+				break
+			}
+		}
+	}
+}
+
+//registerRefs - Returns the register names item's condition and options use
+func registerRefs(item ConfigItem) []string {
+	texts := []string{item.Condition}
+
+	for _, key := range sortedKeys(item.Options) {
+		texts = append(texts, optionString(normalizeValue(item.Options[key])))
+	}
+
+	var names []string
+
+	for _, text := range texts {
+		for _, match := range registerRef.FindAllStringSubmatch(text, -1) {
+			names = append(names, match[1])
+		}
+	}
+
+	return names
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegisterDeps(t *testing.T) {
+	items := []ConfigItem{
+		{Name: "java version", Register: "java"},
+		{Name: "install java", Condition: `items.java.vars.version < "11"`},
+		{Name: "message", Options: map[string]interface{}{"text": []interface{}{"${items.java.stdout}"}}},
+		{Name: "with prereq", PreReq: []string{"java version"}, Condition: `items.java.state == "error"`},
+		{Name: "unknown", Condition: `items.python.state == "changed"`},
+		{Name: "not a ref", Condition: `myitems.java.x == "1"`},
+	}
+
+	registerDeps(items, newChildScope(nil))
+
+	want := [][]string{nil, {"java version"}, {"java version"}, nil, nil, nil}
+
+	for i, item := range items {
+		if !reflect.DeepEqual(item.After, want[i]) {
+			t.Errorf("%v runs after %v, want %v", item.Name, item.After, want[i])
+		}
+	}
+}
+
+func TestRegisterDepsModuleScope(t *testing.T) {
+	root := newChildScope(nil)
+	module := &moduleInfo{Name: "web", Scope: newChildScope(root)}
+
+	items := []ConfigItem{
+		{Name: "check", Register: "chk"},
+		{Name: "web/check", Register: "chk", module: module},
+		{Name: "web/use", Condition: "items.chk.changed", module: module},
+		{Name: "web/os", Condition: "items.os.state", module: module},
+		{Name: "os", Register: "os"},
+	}
+
+	registerDeps(items, root)
+
+	if !reflect.DeepEqual(items[2].After, []string{"web/check"}) {
+		t.Errorf("web/use runs after %v, want the module's own check", items[2].After)
+	}
+
+	if !reflect.DeepEqual(items[3].After, []string{"os"}) {
+		t.Errorf("web/os runs after %v, want os from the config using the module", items[3].After)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+//itemResult - Holds a config item once it has finished running
+type itemResult struct {
+	Index int        //Index of item in config
+	Item  ConfigItem //Item with its state and reason filled in
+}
+
+//scheduleItems - Runs config items once their prerequisites are done, with up to workers of them at a time.
+//Outside of test mode a reboot, or failures the config's failure policy doesn't allow, stop any new items
+//being started. Items already running are waited on and anything left over stays CFGNotRun. Being
+//interrupted stops the run the same way in any mode. finished, if set, is called as each item finishes or is
+//skipped, before any item waiting on it is started. Returns CFGRebootRequired or CFGInterrupted if the
+//run stopped for those, otherwise the state worked out from every item.
+func scheduleItems(cfg *ConfigInfo, order []int, workers int, test bool, process func(item *ConfigItem) int, finished func(item *ConfigItem)) int {
+	if workers < 1 {
+		workers = 1
+	}
+
+	position := make([]int, len(cfg.Items))
+	waiting := make([]int, len(cfg.Items))
+	dependents := make(map[string][]int)
+	ready := make([]int, 0, len(cfg.Items))
+
+	for n, i := range order {
+		position[i] = n
+	}
+
+	for _, i := range order {
+		item := cfg.Items[i]
+		deps := item.dependencies()
+		waiting[i] = len(deps)
+
+		for _, p := range deps {
+			dependents[p] = append(dependents[p], i)
+		}
+
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	states := make(map[string]int)
+	results := make(chan itemResult)
+	running := 0
+	overall := CFGNotRun
+	stopped := false
+	failures := 0
+
+	finish := func(i int, item ConfigItem) {
+		name := item.Name
+		cfg.Items[i] = item
+		states[name] = item.State
+
+		if finished != nil {
+			finished(&cfg.Items[i])
+		}
+
+		for _, d := range dependents[name] {
+			waiting[d]--
+
+			if waiting[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+
+		//Keep ready items in prerequisite order so a single worker runs them the same way every time.
+		sort.Slice(ready, func(a, b int) bool { return position[ready[a]] < position[ready[b]] })
+	}
+
+	for {
+		if !stopped && interrupt.Interrupted() {
+			fmt.Println("Interrupted!")
+			overall = CFGInterrupted
+			stopped = true
+		}
+
+		for !stopped && running < workers && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			item := cfg.Items[i]
+
+			if prereq := blockedPreReq(item, states, test); prereq != "" {
+				fmt.Printf("Skipping %v as prerequisite %v is %v\n", item.Name, prereq, printCFG(states[prereq]))
+				item.State = CFGSkipOnDep
+				item.Reason = fmt.Sprintf("prerequisite %v is %v", prereq, printCFG(states[prereq]))
+				finish(i, item)
+				continue
+			}
+
+			running++
+
+			go func(i int, item ConfigItem) {
+				item.State = process(&item)
+				results <- itemResult{Index: i, Item: item}
+			}(i, item)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		var result itemResult
+
+		if stopped {
+			result = <-results
+		} else {
+			select {
+			case result = <-results:
+			case <-interrupt.Done():
+				//Loop round so no more items get started, then wait on the ones still running.
+				continue
+			}
+		}
+
+		running--
+		finish(result.Index, result.Item)
+
+		if test || stopped {
+			continue
+		}
+
+		if result.Item.State == CFGRebootRequired {
+			fmt.Println("Requires reboot")
+			overall = CFGRebootRequired
+			stopped = true
+		} else if failed(result.Item) {
+			failures++
+
+			if cfg.OnFailure.Stops(failures) {
+				fmt.Println("Error state!")
+				stopped = true
+			}
+		}
+	}
+
+	if overall == CFGNotRun {
+		return runState(cfg.Items)
+	}
+
+	return overall
+}
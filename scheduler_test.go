@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+//fakeRun - Stands in for running items, returning a fixed state for each one and recording what ran
+type fakeRun struct {
+	mutex    sync.Mutex
+	states   map[string]int //State each item returns, CFGConfigured if not set
+	ran      []string
+	finished []string
+	onRun    func(name string) //Called as an item runs, e.g. to interrupt the run
+}
+
+func (f *fakeRun) process(item *ConfigItem) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.ran = append(f.ran, item.Name)
+
+	if f.onRun != nil {
+		f.onRun(item.Name)
+	}
+
+	if state, ok := f.states[item.Name]; ok {
+		return state
+	}
+
+	return CFGConfigured
+}
+
+func (f *fakeRun) finish(item *ConfigItem) {
+	f.finished = append(f.finished, item.Name)
+}
+
+//schedule - Orders and runs items with f, returning the overall state and the state of each item by name
+func (f *fakeRun) schedule(t *testing.T, items []ConfigItem, policy FailurePolicy, workers int, test bool) (int, map[string]int) {
+	cfg := ConfigInfo{Items: append([]ConfigItem{}, items...), OnFailure: policy}
+	order, err := orderConfigItems(cfg.Items)
+
+	if err != nil {
+		t.Fatalf("order: %v", err)
+	}
+
+	overall := scheduleItems(&cfg, order, workers, test, f.process, f.finish)
+	states := make(map[string]int)
+
+	for _, item := range cfg.Items {
+		states[item.Name] = item.State
+	}
+
+	return overall, states
+}
+
+func TestScheduleItems(t *testing.T) {
+	independent := []ConfigItem{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	tests := []struct {
+		name     string
+		items    []ConfigItem
+		results  map[string]int
+		policy   FailurePolicy
+		test     bool
+		overall  int
+		states   map[string]int //States with one worker
+		parallel map[string]int //States with more workers than items, if they differ
+	}{
+		{
+			name:    "all configured",
+			items:   []ConfigItem{{Name: "a"}, {Name: "b", PreReq: []string{"a"}}, {Name: "c", PreReq: []string{"b"}}},
+			results: map[string]int{"b": CFGChanged},
+			overall: CFGConfigured,
+			states:  map[string]int{"a": CFGConfigured, "b": CFGChanged, "c": CFGConfigured},
+		},
+		{
+			name:     "stop leaves the rest not run",
+			items:    independent,
+			results:  map[string]int{"a": CFGError},
+			overall:  CFGError,
+			states:   map[string]int{"a": CFGError, "b": CFGNotRun, "c": CFGNotRun},
+			parallel: map[string]int{"a": CFGError, "b": CFGConfigured, "c": CFGConfigured},
+		},
+		{
+			name:    "continue skips dependents",
+			items:   []ConfigItem{{Name: "a"}, {Name: "b", PreReq: []string{"a"}}, {Name: "c", PreReq: []string{"b"}}, {Name: "d"}},
+			results: map[string]int{"a": CFGError},
+			policy:  FailurePolicy{Mode: FailContinue},
+			overall: CFGError,
+			states:  map[string]int{"a": CFGError, "b": CFGSkipOnDep, "c": CFGSkipOnDep, "d": CFGConfigured},
+		},
+		{
+			name:     "max failures",
+			items:    []ConfigItem{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}},
+			results:  map[string]int{"a": CFGError, "b": CFGError},
+			policy:   FailurePolicy{MaxFailures: 2},
+			overall:  CFGError,
+			states:   map[string]int{"a": CFGError, "b": CFGError, "c": CFGNotRun, "d": CFGNotRun},
+			parallel: map[string]int{"a": CFGError, "b": CFGError, "c": CFGConfigured, "d": CFGConfigured},
+		},
+		{
+			name:    "max failures not reached",
+			items:   independent,
+			results: map[string]int{"a": CFGError},
+			policy:  FailurePolicy{MaxFailures: 2},
+			overall: CFGError,
+			states:  map[string]int{"a": CFGError, "b": CFGConfigured, "c": CFGConfigured},
+		},
+		{
+			name:    "ignored errors don't stop the run",
+			items:   []ConfigItem{{Name: "a", IgnoreErrors: true}, {Name: "b", PreReq: []string{"a"}}, {Name: "c"}},
+			results: map[string]int{"a": CFGError},
+			overall: CFGConfigured,
+			states:  map[string]int{"a": CFGError, "b": CFGSkipOnDep, "c": CFGConfigured},
+		},
+		{
+			name:     "reboot stops the run",
+			items:    independent,
+			results:  map[string]int{"a": CFGRebootRequired},
+			policy:   FailurePolicy{Mode: FailContinue},
+			overall:  CFGRebootRequired,
+			states:   map[string]int{"a": CFGRebootRequired, "b": CFGNotRun, "c": CFGNotRun},
+			parallel: map[string]int{"a": CFGRebootRequired, "b": CFGConfigured, "c": CFGConfigured},
+		},
+		{
+			name:    "skipped by condition blocks dependents",
+			items:   []ConfigItem{{Name: "a"}, {Name: "b", PreReq: []string{"a"}}},
+			results: map[string]int{"a": CFGSkipOnDep},
+			overall: CFGConfigured,
+			states:  map[string]int{"a": CFGSkipOnDep, "b": CFGSkipOnDep},
+		},
+		{
+			name:    "after runs even if the item it waits for fails",
+			items:   []ConfigItem{{Name: "a"}, {Name: "b", After: []string{"a"}}, {Name: "c", PreReq: []string{"a"}}},
+			results: map[string]int{"a": CFGError},
+			policy:  FailurePolicy{Mode: FailContinue},
+			overall: CFGError,
+			states:  map[string]int{"a": CFGError, "b": CFGConfigured, "c": CFGSkipOnDep},
+		},
+		{
+			name:    "not configured outside test mode fails the run",
+			items:   independent,
+			results: map[string]int{"a": CFGNotConfigured},
+			overall: CFGError,
+			states:  map[string]int{"a": CFGNotConfigured, "b": CFGConfigured, "c": CFGConfigured},
+		},
+		{
+			name:    "test mode tests dependents of not configured items",
+			items:   []ConfigItem{{Name: "a"}, {Name: "b", PreReq: []string{"a"}}, {Name: "c", PreReq: []string{"b"}}, {Name: "d"}},
+			results: map[string]int{"a": CFGNotConfigured, "b": CFGError},
+			test:    true,
+			overall: CFGError,
+			states:  map[string]int{"a": CFGNotConfigured, "b": CFGError, "c": CFGSkipOnDep, "d": CFGConfigured},
+		},
+		{
+			name:    "test mode ignores reboot",
+			items:   independent,
+			results: map[string]int{"a": CFGRebootRequired},
+			test:    true,
+			overall: CFGConfigured,
+			states:  map[string]int{"a": CFGRebootRequired, "b": CFGConfigured, "c": CFGConfigured},
+		},
+	}
+
+	for _, test := range tests {
+		for _, workers := range []int{1, 8} {
+			f := &fakeRun{states: test.results}
+			overall, states := f.schedule(t, test.items, test.policy, workers, test.test)
+
+			want := test.states
+
+			if workers > 1 && test.parallel != nil {
+				want = test.parallel
+			}
+
+			if overall != test.overall {
+				t.Errorf("%v with %v workers: overall %v, want %v", test.name, workers, printCFG(overall), printCFG(test.overall))
+			}
+
+			if !reflect.DeepEqual(states, want) {
+				t.Errorf("%v with %v workers: states %v, want %v", test.name, workers, states, want)
+			}
+
+			//Everything that didn't stay not run is finished exactly once.
+			finished := 0
+
+			for _, state := range states {
+				if state != CFGNotRun {
+					finished++
+				}
+			}
+
+			if len(f.finished) != finished {
+				t.Errorf("%v with %v workers: finished %v, want %v items", test.name, workers, f.finished, finished)
+			}
+		}
+	}
+}
+
+func TestScheduleItemsOrder(t *testing.T) {
+	items := []ConfigItem{
+		{Name: "c", PreReq: []string{"b"}},
+		{Name: "x"},
+		{Name: "b", PreReq: []string{"a"}},
+		{Name: "a"},
+		{Name: "y", After: []string{"c"}},
+	}
+
+	f := &fakeRun{}
+	f.schedule(t, items, FailurePolicy{}, 1, false)
+
+	want := []string{"a", "b", "c", "x", "y"}
+
+	if !reflect.DeepEqual(f.ran, want) {
+		t.Errorf("ran %v, want %v", f.ran, want)
+	}
+}
+
+func TestScheduleItemsInterrupt(t *testing.T) {
+	defer func(old *interruptState) { interrupt = old }(interrupt)
+
+	for _, test := range []bool{false, true} {
+		for _, workers := range []int{1, 8} {
+			interrupt = &interruptState{done: make(chan struct{})}
+
+			f := &fakeRun{
+				states: map[string]int{"a": CFGInterrupted},
+				onRun: func(name string) {
+					if name == "a" {
+						interrupt.trigger(os.Interrupt)
+					}
+				},
+			}
+
+			items := []ConfigItem{{Name: "a"}, {Name: "b", PreReq: []string{"a"}}, {Name: "c", After: []string{"a"}}}
+			overall, states := f.schedule(t, items, FailurePolicy{Mode: FailContinue}, workers, test)
+			want := map[string]int{"a": CFGInterrupted, "b": CFGNotRun, "c": CFGNotRun}
+
+			if overall != CFGInterrupted {
+				t.Errorf("test %v with %v workers: overall %v, want Interrupted", test, workers, printCFG(overall))
+			}
+
+			if !reflect.DeepEqual(states, want) {
+				t.Errorf("test %v with %v workers: states %v, want %v", test, workers, states, want)
+			}
+		}
+	}
+}
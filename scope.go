@@ -0,0 +1,226 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Variable scope layers. Later layers override earlier ones.
+const (
+	LayerBase     = iota //Environment spanr was started with
+	LayerRuntime  = iota //Variables set by runtimes (i.e. PATH)
+	LayerProperty = iota //Properties passed in on command line
+	LayerFact     = iota //Variables found by gatherers
+	LayerSession  = iota //Variables set by resources with ##SPANR[name=value]##
+	layerCount    = iota
+)
+
+//Prefix each layer gets when variables are namespaced, layers without one always use bare names
+var layerPrefixes = [layerCount]string{
+	LayerProperty: PropertyPrefix,
+	LayerFact:     FactPrefix,
+}
+
+//scopeVar - A variable in a scope layer, with the name it was set with
+type scopeVar struct {
+	Name  string
+	Value string
+}
+
+//VarScope - Holds the variables passed to scripts as their environment
+type VarScope struct {
+	mutex      sync.RWMutex
+	parent     *VarScope                       //Scope of the config a module is used by, anything not set here comes from it
+	layers     [layerCount]map[string]scopeVar //Variables of each layer by envKey of their name
+	registered map[string]string               //Results of items that use register, by items.name.field. Never exported.
+}
+
+//newVarScope - Creates a scope with the current process environment as its base layer
+func newVarScope() *VarScope {
+	scope := &VarScope{registered: make(map[string]string)}
+
+	for i := range scope.layers {
+		scope.layers[i] = make(map[string]scopeVar)
+	}
+
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+
+		//Skip windows drive variables like =C:=C:\ which have no name.
+		if len(pair) != 2 || pair[0] == "" {
+			continue
+		}
+
+		scope.layers[LayerBase][envKey(pair[0])] = scopeVar{Name: pair[0], Value: pair[1]}
+	}
+
+	return scope
+}
+
+//newChildScope - Creates an empty scope for a module that falls back to parent for anything it doesn't set
+func newChildScope(parent *VarScope) *VarScope {
+	scope := &VarScope{parent: parent, registered: make(map[string]string)}
+
+	for i := range scope.layers {
+		scope.layers[i] = make(map[string]scopeVar)
+	}
+
+	return scope
+}
+
+//Set - Sets a variable in a layer of the scope. On windows this replaces a variable whose name only differs
+//in case, so setting PATH replaces Path.
+func (s *VarScope) Set(layer int, key string, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.layers[layer][envKey(key)] = scopeVar{Name: key, Value: value}
+}
+
+//Layer - Returns a copy of the variables set in layer, including the parent's
+func (s *VarScope) Layer(layer int) map[string]string {
+	vars := make(map[string]string)
+
+	for _, v := range s.layerVars(layer) {
+		vars[v.Name] = v.Value
+	}
+
+	return vars
+}
+
+//layerVars - Returns the variables set in layer by envKey, this scope's replacing the parent's
+func (s *VarScope) layerVars(layer int) map[string]scopeVar {
+	vars := make(map[string]scopeVar)
+
+	if s.parent != nil {
+		vars = s.parent.layerVars(layer)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for key, v := range s.layers[layer] {
+		vars[key] = v
+	}
+
+	return vars
+}
+
+//Register - Stores values for a registered item as items.name.key. Replaces anything registered under name before.
+func (s *VarScope) Register(name string, values map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prefix := registerPrefix + name + "."
+
+	for key := range s.registered {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.registered, key)
+		}
+	}
+
+	for key, val := range values {
+		s.registered[prefix+key] = val
+	}
+}
+
+//Get - Gets a variable from the highest layer that has it set
+func (s *VarScope) Get(key string) (string, bool) {
+	if val, ok := s.getOwn(key); ok || s.parent == nil {
+		return val, ok
+	}
+
+	return s.parent.Get(key)
+}
+
+func (s *VarScope) getOwn(key string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if val, ok := s.registered[key]; ok {
+		return val, true
+	}
+
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		if v, ok := s.layers[i][envKey(key)]; ok {
+			return v.Value, true
+		}
+	}
+
+	return "", false
+}
+
+//Environ - Returns the merged scope with options layered on top in the KEY=VALUE form used by exec.Cmd.
+//naming picks if properties, facts and options get bare names, prefixed names or both.
+func (s *VarScope) Environ(options map[string]string, naming string) []string {
+	merged := s.environMap(naming)
+
+	for key, val := range options {
+		addNamed(merged, naming, OptionPrefix, key, val)
+	}
+
+	env := make([]string, 0, len(merged))
+
+	for _, pair := range merged {
+		env = append(env, pair)
+	}
+
+	sort.Strings(env)
+
+	return env
+}
+
+//environMap - Returns the parent's variables, then this scope's layered on top, named for naming.
+//The map is keyed by envKey so later layers replace variables whatever the case of their name.
+func (s *VarScope) environMap(naming string) map[string]string {
+	merged := make(map[string]string)
+
+	if s.parent != nil {
+		merged = s.parent.environMap(naming)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for i, layer := range s.layers {
+		for _, v := range layer {
+			addNamed(merged, naming, layerPrefixes[i], v.Name, v.Value)
+		}
+	}
+
+	return merged
+}
+
+//Command - Creates a command that runs in dir with the scope and options as its environment.
+//The command is looked up on the scope's PATH so runtimes are found without changing spanr's own PATH.
+func (s *VarScope) Command(dir string, options map[string]string, naming string, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(s.lookPath(name), args...)
+	cmd.Dir = dir
+	cmd.Env = s.Environ(options, naming)
+
+	return cmd
+}
+
+func (s *VarScope) lookPath(name string) string {
+	if strings.ContainsAny(name, `/\`) {
+		return name
+	}
+
+	path, _ := s.Get("PATH")
+
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+
+		if found, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return found
+		}
+	}
+
+	return name
+}
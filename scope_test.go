@@ -0,0 +1,60 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVarScopeRuntimePath(t *testing.T) {
+	scope := newChildScope(nil)
+	scope.Set(LayerBase, "Path", "/usr/bin")
+	scope.Set(LayerRuntime, "PATH", "/runtimes/python")
+
+	var paths []string
+
+	for _, env := range scope.Environ(nil, NamingBoth) {
+		if strings.EqualFold(strings.SplitN(env, "=", 2)[0], "PATH") {
+			paths = append(paths, env)
+		}
+	}
+
+	got, _ := scope.Get("PATH")
+
+	if runtime.GOOS == "windows" {
+		//Windows names aren't case sensitive so the runtime PATH has to replace Path.
+		if len(paths) != 1 || paths[0] != "PATH=/runtimes/python" || got != "/runtimes/python" {
+			t.Errorf("got %v and Get(PATH) = %q, want only PATH=/runtimes/python", paths, got)
+		}
+
+		return
+	}
+
+	if len(paths) != 2 || got != "/runtimes/python" {
+		t.Errorf("got %v and Get(PATH) = %q, want Path and PATH kept apart", paths, got)
+	}
+}
+
+func TestVarScopeChildOverridesParent(t *testing.T) {
+	parent := newChildScope(nil)
+	parent.Set(LayerFact, "os_family", "debian")
+	parent.Set(LayerFact, "role", "web")
+
+	child := newChildScope(parent)
+	child.Set(LayerFact, "role", "db")
+
+	if got, _ := child.Get("role"); got != "db" {
+		t.Errorf("child Get(role) = %q, want db", got)
+	}
+
+	if got, _ := child.Get("os_family"); got != "debian" {
+		t.Errorf("child Get(os_family) = %q, want debian", got)
+	}
+
+	env := strings.Join(child.Environ(map[string]string{"port": "80"}, NamingPrefixed), " ")
+	want := "SPANR_FACT_os_family=debian SPANR_FACT_role=db SPANR_OPT_port=80"
+
+	if env != want {
+		t.Errorf("Environ = %q, want %q", env, want)
+	}
+}
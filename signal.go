@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//How long a script gets to exit after being passed an interrupt before it is killed
+const interruptGrace = 10 * time.Second
+
+var errInterrupted = errors.New("interrupted")
+
+//interruptState - Tracks whether spanr has been asked to stop by SIGINT or SIGTERM
+type interruptState struct {
+	once   sync.Once
+	done   chan struct{}
+	signal os.Signal
+}
+
+//Signals are delivered to the whole process so there is only ever one interrupt state.
+var interrupt = &interruptState{done: make(chan struct{})}
+
+//watchSignals - Marks the run as interrupted when SIGINT or SIGTERM arrives. Call the returned function to stop watching.
+func watchSignals() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		for sig := range signals {
+			interrupt.trigger(sig)
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+func (i *interruptState) trigger(sig os.Signal) {
+	i.once.Do(func() {
+		i.signal = sig
+		close(i.done)
+	})
+}
+
+//Done - Returns a channel that is closed once spanr has been interrupted
+func (i *interruptState) Done() <-chan struct{} {
+	return i.done
+}
+
+//Interrupted - Returns true once spanr has been interrupted
+func (i *interruptState) Interrupted() bool {
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+//Signal - Returns the signal spanr was interrupted with
+func (i *interruptState) Signal() os.Signal {
+	<-i.done
+	return i.signal
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/tucnak/climax"
 )
@@ -67,12 +68,86 @@ func main() {
 				Help:     "Specify path to config result",
 				Variable: true,
 			},
+			{
+				Name:     "parallel",
+				Short:    "j",
+				Usage:    "--parallel",
+				Help:     "Number of independent config items to run at the same time",
+				Variable: true,
+			},
+			{
+				Name:     "strict",
+				Usage:    "--strict",
+				Help:     "Fail items with options their resource doesn't declare",
+				Variable: false,
+			},
+			{
+				Name:     "detailed-exitcodes",
+				Usage:    "--detailed-exitcodes",
+				Help:     "Exit with 0 for no changes, 2 for changes, 4 for failures and 6 for changes and failures",
+				Variable: false,
+			},
+			{
+				Name:     "log-dir",
+				Usage:    "--log-dir",
+				Help:     "Write a log file for each config item and phase to this folder",
+				Variable: true,
+			},
+			{
+				Name:     "timeout",
+				Usage:    "--timeout",
+				Help:     "Maximum time the whole run may take (e.g. 30m)",
+				Variable: true,
+			},
+			{
+				Name:     "naming",
+				Usage:    "--naming",
+				Help:     "How options, properties and facts are named in scripts: both (default), prefixed or bare",
+				Variable: true,
+			},
 		},
 		Handle: func(ctx climax.Context) int {
 			outFile := ctx.Variable["output"]
-			test := ctx.NonVariable["test"]
 
-			result, cfg := runConfig(ctx.Args[0], ctx.Variable["properties"], test, ctx.Variable["config"])
+			opts := RunOptions{
+				Properties: ctx.Variable["properties"],
+				Config:     ctx.Variable["config"],
+				Test:       ctx.NonVariable["test"],
+				Strict:     ctx.NonVariable["strict"],
+				LogDir:     ctx.Variable["log-dir"],
+				Naming:     ctx.Variable["naming"],
+				Parallel:   1,
+			}
+
+			if err := validateNaming(opts.Naming); err != nil {
+				fmt.Printf("Naming is not valid: %v\n", err)
+				os.Exit(5)
+			}
+
+			if parallel, ok := ctx.Get("parallel"); ok {
+				n, err := strconv.Atoi(parallel)
+
+				if err != nil || n < 1 {
+					fmt.Println("Parallel must be a number greater than 0!")
+					os.Exit(5)
+				}
+
+				opts.Parallel = n
+			}
+
+			if timeout, ok := ctx.Get("timeout"); ok {
+				t, err := parseDuration(timeout)
+
+				if err != nil {
+					fmt.Printf("Timeout is not valid: %v\n", err)
+					os.Exit(5)
+				}
+
+				opts.Timeout = t
+			}
+
+			result, cfg := runConfig(ctx.Args[0], opts)
+			cfg.State = result
 
 			if outFile != "" {
 				saveResult(outFile, cfg)
@@ -80,13 +155,7 @@ func main() {
 
 			fmt.Printf("Overall State: %v\n", printCFG(result))
 
-			if result == CFGRebootRequired {
-				os.Exit(3010)
-			} else if result == CFGConfigured {
-				os.Exit(0)
-			} else {
-				os.Exit(5)
-			}
+			os.Exit(exitCode(result, cfg, ctx.NonVariable["detailed-exitcodes"]))
 
 			return 0
 		},
@@ -97,3 +166,41 @@ func main() {
 	clihandler.AddCommand(listcmd)
 	clihandler.Run()
 }
+
+//exitCode - Works out the exit code for the result of a run.
+//With detailed codes 2 is added if anything changed and 4 if anything failed.
+func exitCode(result int, cfg ConfigInfo, detailed bool) int {
+	switch result {
+	case CFGRebootRequired:
+		return 3010
+	case CFGInterrupted:
+		return 130
+	case CFGNotApplicable:
+		return 3
+	case CFGNotConfigured:
+		return 1
+	}
+
+	if !detailed {
+		if result == CFGConfigured || result == CFGChanged {
+			return 0
+		}
+
+		return 5
+	}
+
+	code := 0
+
+	for _, item := range cfg.allItems() {
+		if item.State == CFGChanged {
+			code |= 2
+			break
+		}
+	}
+
+	if result == CFGError {
+		code |= 4
+	}
+
+	return code
+}
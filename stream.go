@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+//Most of each output stream kept in memory for the result, anything before this is dropped
+const maxCapturedOutput = 64 * 1024
+
+//lineWriter - Splits what is written to it into lines and passes each one on as soon as it is complete.
+//Lines longer than max are passed on in pieces so memory use stays bounded.
+type lineWriter struct {
+	line func(string)
+	max  int
+	buf  []byte
+}
+
+func newLineWriter(max int, line func(string)) *lineWriter {
+	return &lineWriter{line: line, max: max}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+
+		if i != -1 && len(bytes.TrimRight(w.buf[:i], "\r")) <= w.max {
+			w.line(string(bytes.TrimRight(w.buf[:i], "\r")))
+			w.buf = w.buf[i+1:]
+		} else if len(w.buf) > w.max {
+			w.line(string(w.buf[:w.max]))
+			w.buf = w.buf[w.max:]
+		} else {
+			break
+		}
+	}
+
+	//Copy what is left so the consumed part of the old array can be freed.
+	w.buf = append([]byte(nil), w.buf...)
+
+	return len(p), nil
+}
+
+//Flush - Passes on the last line if it didn't end with a newline
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.line(string(bytes.TrimRight(w.buf, "\r")))
+		w.buf = nil
+	}
+}
+
+//tailBuffer - Keeps the last max bytes written to it
+type tailBuffer struct {
+	max     int
+	data    []byte
+	dropped int
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.data = append(t.data, p...)
+
+	//Trim once the buffer is twice the limit so dropping old output isn't done on every write.
+	if len(t.data) > 2*t.max {
+		drop := len(t.data) - t.max
+		t.dropped += drop
+		t.data = append([]byte(nil), t.data[drop:]...)
+	}
+
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	data := t.data
+	dropped := t.dropped
+
+	if len(data) > t.max {
+		dropped += len(data) - t.max
+		data = data[len(data)-t.max:]
+	}
+
+	if dropped == 0 {
+		return string(data)
+	}
+
+	return fmt.Sprintf("...(%v bytes not kept)...\n%s", dropped, data)
+}
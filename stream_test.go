@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		max    int
+		lines  []string
+	}{
+		{"one line", []string{"hello\n"}, 100, []string{"hello"}},
+		{"split across writes", []string{"hel", "lo\nwor", "ld\n"}, 100, []string{"hello", "world"}},
+		{"crlf", []string{"a\r\nb\r", "\n"}, 100, []string{"a", "b"}},
+		{"blank lines", []string{"\n\na\n"}, 100, []string{"", "", "a"}},
+		{"no newline at the end", []string{"a\nb"}, 100, []string{"a", "b"}},
+		{"long line split", []string{"abcdefghij\n"}, 4, []string{"abcd", "efgh", "ij"}},
+		{"crlf after a full line", []string{"abcd\r\nef\n"}, 4, []string{"abcd", "ef"}},
+		{"long line over writes", []string{"abc", "def", "gh"}, 4, []string{"abcd", "efgh"}},
+		{"nothing", nil, 100, nil},
+	}
+
+	for _, test := range tests {
+		var lines []string
+		w := newLineWriter(test.max, func(line string) { lines = append(lines, line) })
+
+		for _, text := range test.writes {
+			if n, err := w.Write([]byte(text)); n != len(text) || err != nil {
+				t.Errorf("%v: Write(%q) = %v, %v", test.name, text, n, err)
+			}
+		}
+
+		w.Flush()
+		w.Flush()
+
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%v: lines %q, want %q", test.name, lines, test.lines)
+		}
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"under the limit", []string{"ab", "c"}, "abc"},
+		{"at the limit", []string{"abcd"}, "abcd"},
+		{"over the limit before trimming", []string{"abcdef"}, "...(2 bytes not kept)...\ncdef"},
+		{"trimmed", []string{"abcdef", "ghijk"}, "...(7 bytes not kept)...\nhijk"},
+		{"trimmed then more", []string{"abcdefghi", "jk"}, "...(7 bytes not kept)...\nhijk"},
+		{"nothing", nil, ""},
+	}
+
+	for _, test := range tests {
+		b := newTailBuffer(4)
+
+		for _, text := range test.writes {
+			if n, err := b.Write([]byte(text)); n != len(text) || err != nil {
+				t.Errorf("%v: Write(%q) = %v, %v", test.name, text, n, err)
+			}
+		}
+
+		if got := b.String(); got != test.want {
+			t.Errorf("%v: %q, want %q", test.name, got, test.want)
+		}
+	}
+}
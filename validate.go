@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//validateItem - Checks item's options against the properties declared by its resource.
+//Missing mandatory properties and values that don't fit the property schema are errors.
+//Options the resource doesn't declare are warnings, or errors when strict.
+//Until options have been expanded, values that reference variables can't be checked and are skipped.
+func validateItem(item ConfigItem, resource ResourceInfo, strict bool, expanded bool) (warnings []string, errs []string) {
+	names := make([]string, 0, len(resource.Properties))
+
+	for name := range resource.Properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop := resource.Properties[name]
+		val, ok := item.Options[name]
+
+		if !ok {
+			if prop.Required() {
+				errs = append(errs, fmt.Sprintf("item %v is missing mandatory property %v of resource %v", item.Name, name, resource.Name))
+			}
+
+			//Defaults can reference variables too.
+			if prop.HasDefault() && (expanded || !valueHasVarRefs(prop.Default)) {
+				if err := prop.Validate(prop.Default); err != nil {
+					errs = append(errs, fmt.Sprintf("item %v uses invalid default of property %v for resource %v: %v", item.Name, name, resource.Name, err))
+				}
+			}
+
+			continue
+		}
+
+		if !expanded && valueHasVarRefs(val) {
+			continue
+		}
+
+		if err := prop.Validate(val); err != nil {
+			errs = append(errs, fmt.Sprintf("item %v has invalid property %v for resource %v: %v", item.Name, name, resource.Name, err))
+		}
+	}
+
+	options := make([]string, 0, len(item.Options))
+
+	for name := range item.Options {
+		options = append(options, name)
+	}
+
+	sort.Strings(options)
+
+	for _, name := range options {
+		if _, ok := resource.Properties[name]; ok {
+			continue
+		}
+
+		msg := fmt.Sprintf("item %v has option %v which resource %v doesn't declare", item.Name, name, resource.Name)
+
+		if strict {
+			errs = append(errs, msg)
+		} else {
+			warnings = append(warnings, msg)
+		}
+	}
+
+	return warnings, errs
+}
+
+//preflightConfig - Validates every item and handler in the config before anything is run. Items that fail are
+//marked CFGError with the reason. Returns false if any item failed.
+func preflightConfig(cfg *ConfigInfo, resources []ResourceInfo, strict bool, naming string) bool {
+	ok := true
+	handlers := make(map[string]bool)
+	registered := make(map[string]string)
+
+	for _, h := range cfg.Handlers {
+		handlers[h.Name] = true
+	}
+
+	for i, item := range cfg.Items {
+		errs := checkRegister(item, registered)
+
+		for _, h := range item.Notify {
+			if !handlers[h] {
+				errs = append(errs, fmt.Sprintf("item %v notifies unknown handler %v", item.Name, h))
+			}
+		}
+
+		if !preflightItem(&cfg.Items[i], resources, strict, naming, errs) {
+			ok = false
+		}
+	}
+
+	for i, h := range cfg.Handlers {
+		errs := checkRegister(h, registered)
+
+		if h.Loop != nil {
+			errs = append(errs, fmt.Sprintf("handler %v can't have a loop", h.Name))
+		}
+
+		if len(h.Notify) > 0 {
+			errs = append(errs, fmt.Sprintf("handler %v can't notify other handlers", h.Name))
+		}
+
+		if h.Module != "" {
+			errs = append(errs, fmt.Sprintf("handler %v can't be a module", h.Name))
+		}
+
+		if !preflightItem(&cfg.Handlers[i], resources, strict, naming, errs) {
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+//checkRegister - Checks the name item registers under is valid and not used by another item in registered
+func checkRegister(item ConfigItem, registered map[string]string) []string {
+	if item.Register == "" {
+		return nil
+	}
+
+	if item.IsLoop {
+		return []string{fmt.Sprintf("item %v can't register as it was created by a loop", item.Name)}
+	}
+
+	if !isName(item.Register) {
+		return []string{fmt.Sprintf("item %v has invalid register name %q", item.Name, item.Register)}
+	}
+
+	//Modules have their own scope so names only have to be unique in each module.
+	key := moduleName(item) + "/" + item.Register
+
+	if other, ok := registered[key]; ok {
+		return []string{fmt.Sprintf("item %v registers %v which item %v already uses", item.Name, item.Register, other)}
+	}
+
+	registered[key] = item.Name
+
+	return nil
+}
+
+//preflightItem - Validates item against its resource, adding to errs found by the caller. Marks the item
+//CFGError and returns false if there are any errors.
+func preflightItem(item *ConfigItem, resources []ResourceInfo, strict bool, naming string, errs []string) bool {
+	resources, _ = itemContext(item, resources, nil)
+	resource, err := findResource(item.Resource, resources)
+
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("item %v uses unknown resource %v", item.Name, item.Resource))
+
+		for _, e := range errs {
+			fmt.Printf("ERROR: %v\n", e)
+		}
+
+		item.State = CFGError
+		item.Reason = strings.Join(errs, "; ")
+		return false
+	}
+
+	warnings, itemErrs := validateItem(*item, resource, strict, false)
+	errs = append(errs, itemErrs...)
+
+	if err := validateResourceProtocol(resource); err != nil {
+		errs = append(errs, fmt.Sprintf("resource %v: %v", resource.Name, err))
+	}
+
+	if err := validateInput(resource.Input); err != nil {
+		errs = append(errs, fmt.Sprintf("resource %v: %v", resource.Name, err))
+	}
+
+	if err := validateNaming(resource.Naming); err != nil {
+		errs = append(errs, fmt.Sprintf("resource %v: %v", resource.Name, err))
+	}
+
+	warnings = append(warnings, shadowWarnings(*item, resource, resolveNaming(resource.Naming, naming))...)
+
+	for _, d := range []string{item.Timeout, item.Delay, item.Until} {
+		if _, err := parseDuration(d); err != nil {
+			errs = append(errs, fmt.Sprintf("item %v: %v", item.Name, err))
+		}
+	}
+
+	if item.Retries < 0 {
+		errs = append(errs, fmt.Sprintf("item %v: retries can't be negative", item.Name))
+	}
+
+	if item.Condition != "" {
+		if _, err := parseCondition(item.Condition); err != nil {
+			errs = append(errs, fmt.Sprintf("item %v has invalid condition %q: %v", item.Name, item.Condition, err))
+		}
+	}
+
+	for _, w := range warnings {
+		fmt.Printf("WARNING: %v\n", w)
+	}
+
+	for _, e := range errs {
+		fmt.Printf("ERROR: %v\n", e)
+	}
+
+	if len(errs) > 0 {
+		item.State = CFGError
+		item.Reason = strings.Join(errs, "; ")
+		return false
+	}
+
+	return true
+}
+
+//shadowWarnings - Warns about options that would replace a critical environment variable like PATH
+func shadowWarnings(item ConfigItem, resource ResourceInfo, naming string) []string {
+	if naming == NamingPrefixed || resource.Input == InputJSON {
+		return nil
+	}
+
+	var warnings []string
+
+	for key := range resourceOptions(item, resource) {
+		if isCriticalVar(key) {
+			warnings = append(warnings, fmt.Sprintf("item %v option %v shadows the %v environment variable, set naming: %v on resource %v to avoid this",
+				item.Name, key, strings.ToUpper(key), NamingPrefixed, resource.Name))
+		}
+	}
+
+	sort.Strings(warnings)
+
+	return warnings
+}